@@ -23,6 +23,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"github.com/digitalocean/csi-digitalocean/driver"
@@ -34,10 +35,20 @@ func main() {
 		token      = flag.String("token", "", "DigitalOcean access token.")
 		url        = flag.String("url", "https://api.digitalocean.com/", "DigitalOcean API URL.")
 		region     = flag.String("region", "", "DigitalOcean region slug. Specify only when running in controller mode outside of a DigitalOcean droplet.")
+		regions    = flag.String("regions", "", "Comma-separated list of additional DigitalOcean region slugs to create and manage volumes in. Only used in controller mode.")
 		doTag      = flag.String("do-tag", "", "Tag DigitalOcean volumes on Create/Attach.")
 		driverName = flag.String("driver-name", driver.DefaultDriverName, "Name for the driver.")
 		debugAddr  = flag.String("debug-addr", "", "Address to serve the HTTP debug server on.")
 		version    = flag.Bool("version", false, "Print the version and exit.")
+
+		apiRateLimit         = flag.Float64("api-rate-limit", 0, "Maximum number of DigitalOcean API requests per second. Zero disables client-side rate limiting.")
+		apiRateBurst         = flag.Int("api-rate-burst", 10, "Maximum burst of DigitalOcean API requests when --api-rate-limit is set.")
+		apiMaxRetries        = flag.Int("api-max-retries", driver.DefaultAPIMaxRetries, "Number of times a rate limited or failed idempotent DigitalOcean API request is retried.")
+		deviceWaitTimeout    = flag.Duration("device-wait-timeout", driver.DefaultDeviceWaitTimeout, "Time to wait for the device of an attached volume to appear on the node.")
+		maxVolumesPerNode    = flag.Int("max-volumes-per-node", driver.DefaultMaxVolumesPerNode, "Number of volumes that can be attached to the node, including volumes attached outside of Kubernetes. Overrides the per-Droplet limit of DigitalOcean, which the Droplet metadata does not expose. Only used in node mode.")
+		listUnownedResources = flag.Bool("list-unowned-resources", false, "List all volumes and snapshots of the account rather than only those tagged with --do-tag. Only used in controller mode.")
+		topologyMode         = flag.String("topology-mode", driver.DefaultTopologyMode, "Topology keys to publish the region under: \"legacy\" (region), \"standard\" (topology.kubernetes.io/region) or \"migration\" (both).")
+		actionStateFile      = flag.String("action-state-file", "", "File to persist in-progress volume actions to so that they can be resumed after a restart. Only used in controller mode.")
 	)
 	flag.Parse()
 
@@ -50,7 +61,34 @@ func main() {
 		log.Fatalln("region flag must not be set when driver is running in node mode (i.e., token flag is unset)")
 	}
 
-	drv, err := driver.NewDriver(*endpoint, *token, *url, *region, *doTag, *driverName, *debugAddr)
+	var additionalRegions []string
+	if *regions != "" {
+		if *token == "" {
+			log.Fatalln("regions flag must not be set when driver is running in node mode (i.e., token flag is unset)")
+		}
+		for _, r := range strings.Split(*regions, ",") {
+			additionalRegions = append(additionalRegions, strings.TrimSpace(r))
+		}
+	}
+
+	drv, err := driver.NewDriver(driver.NewDriverParams{
+		Endpoint:             *endpoint,
+		Token:                *token,
+		URL:                  *url,
+		Region:               *region,
+		Regions:              additionalRegions,
+		DOTag:                *doTag,
+		DriverName:           *driverName,
+		DebugAddr:            *debugAddr,
+		APIRateLimit:         *apiRateLimit,
+		APIRateBurst:         *apiRateBurst,
+		APIMaxRetries:        *apiMaxRetries,
+		ActionStateFile:      *actionStateFile,
+		DeviceWaitTimeout:    *deviceWaitTimeout,
+		MaxVolumesPerNode:    *maxVolumesPerNode,
+		TopologyMode:         *topologyMode,
+		ListUnownedResources: *listUnownedResources,
+	})
 	if err != nil {
 		log.Fatalln(err)
 	}
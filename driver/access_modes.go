@@ -0,0 +1,62 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"path/filepath"
+
+	"k8s.io/mount-utils"
+)
+
+// findBindMountTargets returns the mount points the given source is bind
+// mounted to. The source is either the staging path of a filesystem volume or
+// the device of a raw block volume.
+func findBindMountTargets(mountInfos []mount.MountInfo, source string, isDevice bool) []string {
+	var targets []string
+
+	if isDevice {
+		name := filepath.Base(source)
+		if resolved, err := filepath.EvalSymlinks(source); err == nil {
+			name = filepath.Base(resolved)
+		}
+
+		for _, mi := range mountInfos {
+			if mi.FsType == "devtmpfs" && mountedDeviceName(mi) == name {
+				targets = append(targets, mi.MountPoint)
+			}
+		}
+		return targets
+	}
+
+	var staging *mount.MountInfo
+	for i, mi := range mountInfos {
+		if mi.MountPoint == source {
+			staging = &mountInfos[i]
+		}
+	}
+	if staging == nil {
+		return nil
+	}
+
+	for _, mi := range mountInfos {
+		if mi.MountPoint == source || mi.Major != staging.Major || mi.Minor != staging.Minor || mi.Root != staging.Root {
+			continue
+		}
+		targets = append(targets, mi.MountPoint)
+	}
+	return targets
+}
@@ -0,0 +1,282 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/google/go-cmp/cmp"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/mount-utils"
+)
+
+func TestFindBindMountTargets(t *testing.T) {
+	mountInfos := []mount.MountInfo{
+		{Major: 8, Minor: 16, Root: "/", Source: "/dev/sdb", FsType: "ext4", MountPoint: "/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pv-1/globalmount"},
+		{Major: 8, Minor: 16, Root: "/", Source: "/dev/sdb", FsType: "ext4", MountPoint: "/var/lib/kubelet/pods/a/volumes/kubernetes.io~csi/pv-1/mount"},
+		{Major: 8, Minor: 16, Root: "/lost+found", Source: "/dev/sdb", FsType: "ext4", MountPoint: "/mnt/lost"},
+		{Major: 8, Minor: 32, Root: "/", Source: "/dev/sdc", FsType: "ext4", MountPoint: "/var/lib/kubelet/pods/b/volumes/kubernetes.io~csi/pv-2/mount"},
+		{Major: 0, Minor: 5, Root: "/", Source: "udev", FsType: "devtmpfs", MountPoint: "/dev"},
+		{Major: 0, Minor: 5, Root: "/sdd", Source: "udev", FsType: "devtmpfs", MountPoint: "/var/lib/kubelet/plugins/kubernetes.io/csi/volumeDevices/publish/pv-3/c"},
+		{Major: 0, Minor: 5, Root: "/sde", Source: "udev", FsType: "devtmpfs", MountPoint: "/var/lib/kubelet/plugins/kubernetes.io/csi/volumeDevices/publish/pv-4/d"},
+	}
+
+	tests := []struct {
+		name        string
+		source      string
+		isDevice    bool
+		wantTargets []string
+	}{
+		{
+			name:        "staging path",
+			source:      "/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pv-1/globalmount",
+			wantTargets: []string{"/var/lib/kubelet/pods/a/volumes/kubernetes.io~csi/pv-1/mount"},
+		},
+		{
+			name:   "unmounted staging path",
+			source: "/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pv-5/globalmount",
+		},
+		{
+			name:        "block device",
+			source:      "/dev/sdd",
+			isDevice:    true,
+			wantTargets: []string{"/var/lib/kubelet/plugins/kubernetes.io/csi/volumeDevices/publish/pv-3/c"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			targets := findBindMountTargets(mountInfos, test.source, test.isDevice)
+			if diff := cmp.Diff(test.wantTargets, targets); diff != "" {
+				t.Errorf("targets mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNodePublishVolumeSingleWriter(t *testing.T) {
+	const stagingPath = "/staging"
+
+	tests := []struct {
+		name     string
+		mode     csi.VolumeCapability_AccessMode_Mode
+		target   string
+		wantCode codes.Code
+	}{
+		{
+			name:   "single writer on the same target",
+			mode:   csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+			target: "/target-1",
+		},
+		{
+			name:     "single writer on another target",
+			mode:     csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+			target:   "/target-2",
+			wantCode: codes.FailedPrecondition,
+		},
+		{
+			name:   "multi writer on another target",
+			mode:   csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
+			target: "/target-2",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := &Driver{
+				mounter: &fakeMounter{
+					mounted: map[string]string{"/target-1": stagingPath},
+				},
+				log: logrus.New().WithField("test_enabed", true),
+			}
+
+			_, err := d.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:          "volume-id",
+				StagingTargetPath: stagingPath,
+				TargetPath:        test.target,
+				PublishContext:    map[string]string{},
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: test.mode,
+					},
+				},
+			})
+			if code := status.Code(err); code != test.wantCode {
+				t.Errorf("got code %s, want %s (error: %v)", code, test.wantCode, err)
+			}
+		})
+	}
+}
+
+func TestSingleNodeMultiWriterCapability(t *testing.T) {
+	d := &Driver{
+		log: logrus.New().WithField("test_enabed", true),
+	}
+
+	controllerResp, err := d.ControllerGetCapabilities(context.Background(), &csi.ControllerGetCapabilitiesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotController bool
+	for _, cap := range controllerResp.GetCapabilities() {
+		if cap.GetRpc().GetType() == csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER {
+			gotController = true
+		}
+	}
+	if !gotController {
+		t.Error("controller capability not announced")
+	}
+
+	nodeResp, err := d.NodeGetCapabilities(context.Background(), &csi.NodeGetCapabilitiesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotNode bool
+	for _, cap := range nodeResp.GetCapabilities() {
+		if cap.GetRpc().GetType() == csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER {
+			gotNode = true
+		}
+	}
+	if !gotNode {
+		t.Error("node capability not announced")
+	}
+}
+
+func TestBlockDeviceReadOnly(t *testing.T) {
+	const device = "/dev/sdb"
+
+	tests := []struct {
+		name     string
+		mounted  map[string]string
+		readOnly bool
+		flag     bool
+		wantCode codes.Code
+		wantFlag bool
+	}{
+		{
+			name:     "first publication read-only",
+			mounted:  map[string]string{},
+			readOnly: true,
+			wantFlag: true,
+		},
+		{
+			name:     "first publication read-write",
+			mounted:  map[string]string{},
+			flag:     true,
+			wantFlag: false,
+		},
+		{
+			name:     "read-only next to read-only",
+			mounted:  map[string]string{"/target-1": device},
+			readOnly: true,
+			flag:     true,
+			wantFlag: true,
+		},
+		{
+			name:     "read-write next to read-only",
+			mounted:  map[string]string{"/target-1": device},
+			flag:     true,
+			wantCode: codes.FailedPrecondition,
+			wantFlag: true,
+		},
+		{
+			name:     "read-only next to read-write",
+			mounted:  map[string]string{"/target-1": device},
+			readOnly: true,
+			wantCode: codes.FailedPrecondition,
+			wantFlag: false,
+		},
+		{
+			name:     "retried publication",
+			mounted:  map[string]string{"/target-2": device},
+			readOnly: true,
+			flag:     true,
+			wantFlag: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := &fakeMounter{
+				mounted:         test.mounted,
+				readOnlyDevices: map[string]bool{device: test.flag},
+			}
+			d := &Driver{mounter: m}
+
+			log := logrus.New().WithField("test_enabed", true)
+			err := d.setBlockDeviceReadOnly("volume-id", device, "/target-2", test.readOnly, log)
+			if code := status.Code(err); code != test.wantCode {
+				t.Errorf("got code %s, want %s (error: %v)", code, test.wantCode, err)
+			}
+			if flag := m.readOnlyDevices[device]; flag != test.wantFlag {
+				t.Errorf("got read-only flag %t, want %t", flag, test.wantFlag)
+			}
+		})
+	}
+}
+
+func TestNodeUnpublishVolumeResetsBlockDeviceReadOnly(t *testing.T) {
+	const device = "/dev/sdb"
+
+	tests := []struct {
+		name     string
+		mounted  map[string]string
+		wantFlag bool
+	}{
+		{
+			name:     "last publication",
+			mounted:  map[string]string{"/target-1": device},
+			wantFlag: false,
+		},
+		{
+			name:     "other publication left",
+			mounted:  map[string]string{"/target-1": device, "/target-2": device},
+			wantFlag: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := &fakeMounter{
+				mounted:         test.mounted,
+				readOnlyDevices: map[string]bool{device: true},
+			}
+			d := &Driver{
+				mounter: m,
+				log:     logrus.New().WithField("test_enabed", true),
+			}
+
+			_, err := d.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+				VolumeId:   "volume-id",
+				TargetPath: "/target-1",
+			})
+			if err != nil {
+				t.Fatalf("got error: %s", err)
+			}
+			if flag := m.readOnlyDevices[device]; flag != test.wantFlag {
+				t.Errorf("got read-only flag %t, want %t", flag, test.wantFlag)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// trackedAction is a volume action issued by the controller that has not been
+// observed to finish yet.
+type trackedAction struct {
+	ID        int    `json:"id"`
+	Type      string `json:"type"`
+	DropletID int    `json:"droplet_id,omitempty"`
+}
+
+// actionTracker keeps track of the outstanding action of each volume so that
+// a retried request can resume waiting on it instead of issuing the action
+// again. The actions are optionally persisted to a state file to survive
+// controller restarts. All methods are safe to call on a nil receiver.
+type actionTracker struct {
+	mu sync.Mutex
+	// path is the state file; an empty path keeps the actions in memory only.
+	path    string
+	actions map[string]trackedAction
+}
+
+// newActionTracker returns an actionTracker persisting to the given state
+// file, if any, initialized with the actions found in it.
+func newActionTracker(path string) (*actionTracker, error) {
+	t := &actionTracker{
+		path:    path,
+		actions: map[string]trackedAction{},
+	}
+
+	if path == "" {
+		return t, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return t, nil
+		}
+		return nil, fmt.Errorf("failed to read action state file: %s", err)
+	}
+
+	if err := json.Unmarshal(data, &t.actions); err != nil {
+		return nil, fmt.Errorf("failed to parse action state file %s: %s", path, err)
+	}
+	return t, nil
+}
+
+// get returns the outstanding action of the given volume, if any.
+func (t *actionTracker) get(volumeID string) (trackedAction, bool) {
+	if t == nil {
+		return trackedAction{}, false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	action, ok := t.actions[volumeID]
+	return action, ok
+}
+
+// track records the given action as the outstanding action of the volume.
+func (t *actionTracker) track(volumeID string, action trackedAction) error {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.actions[volumeID] = action
+	return t.save()
+}
+
+// untrack forgets the given action of the volume. Newer actions of the volume
+// are kept.
+func (t *actionTracker) untrack(volumeID string, actionID int) error {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if action, ok := t.actions[volumeID]; !ok || action.ID != actionID {
+		return nil
+	}
+
+	delete(t.actions, volumeID)
+	return t.save()
+}
+
+// save writes the actions to the state file. The caller must hold the lock.
+func (t *actionTracker) save() error {
+	if t.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(t.actions)
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first so that a crash never leaves a
+	// truncated state file behind
+	tmpFile, err := ioutil.TempFile(filepath.Dir(t.path), filepath.Base(t.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write action state file: %s", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to write action state file: %s", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to write action state file: %s", err)
+	}
+
+	if err := os.Rename(tmpFile.Name(), t.path); err != nil {
+		return fmt.Errorf("failed to write action state file: %s", err)
+	}
+	return nil
+}
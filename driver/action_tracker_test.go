@@ -0,0 +1,79 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestActionTrackerStateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "action-tracker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "actions.json")
+
+	tracker, err := newActionTracker(path)
+	if err != nil {
+		t.Fatalf("got error creating tracker without state file: %s", err)
+	}
+
+	want := trackedAction{ID: 42, Type: "attach_volume", DropletID: 7}
+	if err := tracker.track("volume-1", want); err != nil {
+		t.Fatalf("got error tracking action: %s", err)
+	}
+	if err := tracker.track("volume-2", trackedAction{ID: 43, Type: "detach_volume"}); err != nil {
+		t.Fatalf("got error tracking action: %s", err)
+	}
+	// untracking an outdated action must keep the current one
+	if err := tracker.untrack("volume-2", 1); err != nil {
+		t.Fatalf("got error untracking action: %s", err)
+	}
+	if err := tracker.untrack("volume-2", 43); err != nil {
+		t.Fatalf("got error untracking action: %s", err)
+	}
+
+	restored, err := newActionTracker(path)
+	if err != nil {
+		t.Fatalf("got error restoring tracker: %s", err)
+	}
+
+	got, ok := restored.get("volume-1")
+	if !ok || got != want {
+		t.Errorf("got action %+v (found: %t), want %+v", got, ok, want)
+	}
+	if _, ok := restored.get("volume-2"); ok {
+		t.Error("got untracked action for volume-2, want none")
+	}
+}
+
+func TestActionTrackerNil(t *testing.T) {
+	var tracker *actionTracker
+	if err := tracker.track("volume", trackedAction{ID: 1}); err != nil {
+		t.Errorf("got error %q, want none", err)
+	}
+	if _, ok := tracker.get("volume"); ok {
+		t.Error("got action from nil tracker, want none")
+	}
+	if err := tracker.untrack("volume", 1); err != nil {
+		t.Errorf("got error %q, want none", err)
+	}
+}
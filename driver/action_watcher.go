@@ -0,0 +1,233 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultActionPollMinInterval = 1 * time.Second
+	defaultActionPollMaxInterval = 10 * time.Second
+	actionPollBackoffFactor      = 1.5
+)
+
+// actionResult is the outcome of a watched action. Either the action has
+// finished (i.e., it completed or errored) or err is set.
+type actionResult struct {
+	action *godo.Action
+	err    error
+}
+
+type actionKey struct {
+	volumeID string
+	actionID int
+}
+
+// actionWatch is the state of a single watched action.
+type actionWatch struct {
+	key         actionKey
+	subscribers map[int]chan actionResult
+	interval    time.Duration
+	next        time.Time
+	polling     bool
+}
+
+// actionWatcher polls the status of storage actions on behalf of all callers
+// waiting for them. Each action is polled once no matter how many callers
+// wait for it, and the polling interval grows exponentially while the action
+// is in progress so that many parallel attaches do not hammer the API.
+type actionWatcher struct {
+	storageActions godo.StorageActionsService
+	log            *logrus.Entry
+	minInterval    time.Duration
+	maxInterval    time.Duration
+
+	mu      sync.Mutex
+	watches map[actionKey]*actionWatch
+	nextSub int
+	running bool
+	// wake interrupts the polling loop when a new action is watched
+	wake chan struct{}
+}
+
+func newActionWatcher(storageActions godo.StorageActionsService, log *logrus.Entry) *actionWatcher {
+	return &actionWatcher{
+		storageActions: storageActions,
+		log:            log,
+		minInterval:    defaultActionPollMinInterval,
+		maxInterval:    defaultActionPollMaxInterval,
+		watches:        map[actionKey]*actionWatch{},
+		wake:           make(chan struct{}, 1),
+	}
+}
+
+// watch registers the caller's interest in the given action. The returned
+// channel receives a single result once the action has finished or can't be
+// polled anymore. The returned function must be called once the caller stops
+// waiting.
+func (w *actionWatcher) watch(volumeID string, actionID int) (<-chan actionResult, func()) {
+	key := actionKey{volumeID: volumeID, actionID: actionID}
+	results := make(chan actionResult, 1)
+
+	w.mu.Lock()
+	watch, ok := w.watches[key]
+	if !ok {
+		watch = &actionWatch{
+			key:         key,
+			subscribers: map[int]chan actionResult{},
+			interval:    w.minInterval,
+			next:        time.Now().Add(w.minInterval),
+		}
+		w.watches[key] = watch
+	}
+	sub := w.nextSub
+	w.nextSub++
+	watch.subscribers[sub] = results
+
+	if !w.running {
+		w.running = true
+		go w.run()
+	}
+	w.mu.Unlock()
+
+	if !ok {
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+
+	return results, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		delete(watch.subscribers, sub)
+		if len(watch.subscribers) == 0 && w.watches[key] == watch {
+			delete(w.watches, key)
+		}
+	}
+}
+
+// run polls the watched actions as they become due. It returns once no
+// actions are watched anymore.
+func (w *actionWatcher) run() {
+	for {
+		w.mu.Lock()
+		if len(w.watches) == 0 {
+			w.running = false
+			w.mu.Unlock()
+			return
+		}
+
+		now := time.Now()
+		nextWake := now.Add(w.maxInterval)
+		for _, watch := range w.watches {
+			if watch.polling {
+				continue
+			}
+			if !watch.next.After(now) {
+				watch.polling = true
+				go w.poll(watch)
+				continue
+			}
+			if watch.next.Before(nextWake) {
+				nextWake = watch.next
+			}
+		}
+		w.mu.Unlock()
+
+		t := time.NewTimer(time.Until(nextWake))
+		select {
+		case <-t.C:
+		case <-w.wake:
+		}
+		t.Stop()
+	}
+}
+
+// poll fetches the status of the given action and either delivers the result
+// to the subscribers or schedules the next poll.
+func (w *actionWatcher) poll(watch *actionWatch) {
+	ctx, cancel := context.WithTimeout(context.Background(), doAPITimeout)
+	defer cancel()
+
+	log := w.log.WithFields(logrus.Fields{
+		"volume_id": watch.key.volumeID,
+		"action_id": watch.key.actionID,
+	})
+
+	action, resp, err := w.storageActions.Get(ctx, watch.key.volumeID, watch.key.actionID)
+
+	w.mu.Lock()
+	defer func() {
+		w.mu.Unlock()
+		// make sure the loop considers the rescheduled watch
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}()
+
+	watch.polling = false
+	if w.watches[watch.key] != watch {
+		// all subscribers are gone
+		return
+	}
+
+	switch {
+	case err != nil && resp != nil && resp.StatusCode == http.StatusNotFound:
+		w.finish(watch, actionResult{
+			err: fmt.Errorf("action %d for volume %s does not exist", watch.key.actionID, watch.key.volumeID),
+		})
+		return
+	case err != nil:
+		// transient failures are retried without backing off further
+		log.WithError(err).Warn("getting action for volume")
+		watch.next = time.Now().Add(watch.interval)
+		return
+	}
+
+	log.WithField("action_status", action.Status).Debug("action received")
+
+	if action.Status == godo.ActionCompleted || action.Status == actionErrored {
+		w.finish(watch, actionResult{action: action})
+		return
+	}
+
+	watch.interval = time.Duration(float64(watch.interval) * actionPollBackoffFactor)
+	if watch.interval > w.maxInterval {
+		watch.interval = w.maxInterval
+	}
+	watch.next = time.Now().Add(watch.interval)
+}
+
+// finish delivers the result to all subscribers and stops watching the
+// action. The caller must hold the lock.
+func (w *actionWatcher) finish(watch *actionWatch, result actionResult) {
+	for _, results := range watch.subscribers {
+		results <- result
+	}
+	delete(w.watches, watch.key)
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/sirupsen/logrus"
+)
+
+// countingStorageActions reports actions as in progress until they have been
+// polled doneAfter times, after which they report the final status.
+type countingStorageActions struct {
+	*fakeStorageActionsDriver
+	doneAfter   int
+	finalStatus string
+
+	mu    sync.Mutex
+	calls map[int]int
+}
+
+func (f *countingStorageActions) Get(ctx context.Context, volumeID string, actionID int) (*godo.Action, *godo.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if actionID < 0 {
+		resp := godoResponse()
+		resp.Response = &http.Response{StatusCode: http.StatusNotFound}
+		return nil, resp, godo.NewArgError("actionID", "not found")
+	}
+
+	f.calls[actionID]++
+	status := godo.ActionInProgress
+	if f.calls[actionID] >= f.doneAfter {
+		status = f.finalStatus
+	}
+	return &godo.Action{ID: actionID, Status: status}, godoResponse(), nil
+}
+
+func TestActionWatcher(t *testing.T) {
+	tests := []struct {
+		name        string
+		actionID    int
+		subscribers int
+		doneAfter   int
+		finalStatus string
+		wantStatus  string
+		wantErr     bool
+		wantCalls   int
+	}{
+		{
+			name:        "completed action polled once for all subscribers",
+			actionID:    1,
+			subscribers: 5,
+			doneAfter:   3,
+			finalStatus: godo.ActionCompleted,
+			wantStatus:  godo.ActionCompleted,
+			wantCalls:   3,
+		},
+		{
+			name:        "errored action reported immediately",
+			actionID:    2,
+			subscribers: 2,
+			doneAfter:   1,
+			finalStatus: actionErrored,
+			wantStatus:  actionErrored,
+			wantCalls:   1,
+		},
+		{
+			name:        "missing action",
+			actionID:    -1,
+			subscribers: 1,
+			wantErr:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storageActions := &countingStorageActions{
+				fakeStorageActionsDriver: &fakeStorageActionsDriver{},
+				doneAfter:                test.doneAfter,
+				finalStatus:              test.finalStatus,
+				calls:                    map[int]int{},
+			}
+			w := newActionWatcher(storageActions, logrus.New().WithField("test_enabed", true))
+			// the first poll is due after minInterval, by which time all
+			// subscribers have registered
+			w.minInterval = 50 * time.Millisecond
+			w.maxInterval = 50 * time.Millisecond
+
+			var wg sync.WaitGroup
+			for i := 0; i < test.subscribers; i++ {
+				results, stop := w.watch("volume-id", test.actionID)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer stop()
+
+					select {
+					case res := <-results:
+						if (res.err != nil) != test.wantErr {
+							t.Errorf("got error %v, want error: %t", res.err, test.wantErr)
+						}
+						if res.err == nil && res.action.Status != test.wantStatus {
+							t.Errorf("got status %q, want %q", res.action.Status, test.wantStatus)
+						}
+					case <-time.After(5 * time.Second):
+						t.Error("timed out waiting for action result")
+					}
+				}()
+			}
+			wg.Wait()
+
+			storageActions.mu.Lock()
+			calls := storageActions.calls[test.actionID]
+			storageActions.mu.Unlock()
+			if calls != test.wantCalls {
+				t.Errorf("got %d polls, want %d", calls, test.wantCalls)
+			}
+
+			w.mu.Lock()
+			numWatches := len(w.watches)
+			w.mu.Unlock()
+			if numWatches != 0 {
+				t.Errorf("got %d leftover watches, want none", numWatches)
+			}
+		})
+	}
+}
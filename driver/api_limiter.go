@@ -0,0 +1,446 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// DefaultAPIMaxRetries is the default number of times a DigitalOcean API
+	// request is retried after being rate limited or, for idempotent
+	// requests, after a transient failure.
+	DefaultAPIMaxRetries = 5
+
+	defaultAPIBaseBackoff = 500 * time.Millisecond
+	defaultAPIMaxBackoff  = 30 * time.Second
+)
+
+// apiLimiter throttles and retries requests to the DigitalOcean API. It keeps
+// a client-side token bucket, honors the RateLimit-* and Retry-After response
+// headers and retries idempotent requests that failed with a transient error
+// using jittered exponential backoff.
+type apiLimiter struct {
+	// limiter is the client-side token bucket. A nil limiter does not
+	// throttle requests.
+	limiter     *rate.Limiter
+	maxRetries  int
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+	log         *logrus.Entry
+
+	mu sync.Mutex
+	// blockedUntil is the time until which no request should be sent because
+	// the server-side rate limit has been exhausted.
+	blockedUntil time.Time
+}
+
+// newAPILimiter returns an apiLimiter allowing qps requests per second with
+// the given burst. A non-positive qps disables client-side throttling.
+func newAPILimiter(qps float64, burst, maxRetries int, log *logrus.Entry) *apiLimiter {
+	var limiter *rate.Limiter
+	if qps > 0 {
+		if burst < 1 {
+			burst = 1
+		}
+		limiter = rate.NewLimiter(rate.Limit(qps), burst)
+	}
+
+	if maxRetries < 0 {
+		maxRetries = 0
+	}
+
+	return &apiLimiter{
+		limiter:     limiter,
+		maxRetries:  maxRetries,
+		baseBackoff: defaultAPIBaseBackoff,
+		maxBackoff:  defaultAPIMaxBackoff,
+		log:         log,
+	}
+}
+
+// do executes the given API call. Calls rejected with 429 Too Many Requests
+// are always retried; other failures are retried only if idempotent is true.
+func (l *apiLimiter) do(ctx context.Context, idempotent bool, call func() (*godo.Response, error)) error {
+	for attempt := 0; ; attempt++ {
+		if err := l.wait(ctx); err != nil {
+			return err
+		}
+
+		resp, err := call()
+		l.observe(resp)
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= l.maxRetries || ctx.Err() != nil {
+			return err
+		}
+
+		var delay time.Duration
+		switch {
+		case resp != nil && resp.StatusCode == http.StatusTooManyRequests:
+			delay = retryAfter(resp)
+			if delay <= 0 {
+				delay = l.backoff(attempt)
+			}
+		case idempotent && isTransientError(resp):
+			delay = l.backoff(attempt)
+		default:
+			return err
+		}
+
+		// there is no point in waiting if the request can't be sent anymore
+		// before the context expires.
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return err
+		}
+
+		if l.log != nil {
+			l.log.WithFields(logrus.Fields{
+				"attempt": attempt + 1,
+				"delay":   delay,
+			}).WithError(err).Warn("retrying DigitalOcean API request")
+		}
+
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// wait blocks until the server-side rate limit has been reset and a token is
+// available in the client-side bucket.
+func (l *apiLimiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	blockedUntil := l.blockedUntil
+	l.mu.Unlock()
+
+	if err := sleep(ctx, time.Until(blockedUntil)); err != nil {
+		return err
+	}
+
+	if l.limiter == nil {
+		return nil
+	}
+	return l.limiter.Wait(ctx)
+}
+
+// observe records the rate limit state reported by the API.
+func (l *apiLimiter) observe(resp *godo.Response) {
+	if resp == nil || resp.Response == nil || resp.Rate.Limit == 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if resp.Rate.Remaining > 0 {
+		l.blockedUntil = time.Time{}
+		return
+	}
+	l.blockedUntil = resp.Rate.Reset.Time
+}
+
+// backoff returns the jittered exponential backoff for the given attempt.
+func (l *apiLimiter) backoff(attempt int) time.Duration {
+	d := l.baseBackoff << uint(attempt)
+	if d <= 0 || d > l.maxBackoff {
+		d = l.maxBackoff
+	}
+	// pick a random duration in [d/2, d) so that concurrent callers do not
+	// retry in lockstep.
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// retryAfter returns how long to wait before retrying a rate limited
+// request. It prefers the Retry-After header and falls back to the reset time
+// of the rate limit.
+func retryAfter(resp *godo.Response) time.Duration {
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			return time.Duration(secs) * time.Second
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return time.Until(t)
+		}
+	}
+
+	if !resp.Rate.Reset.IsZero() {
+		return time.Until(resp.Rate.Reset.Time)
+	}
+	return 0
+}
+
+// isTransientError returns true if the request failed without a response
+// (e.g., a connection reset) or with a server-side error.
+func isTransientError(resp *godo.Response) bool {
+	return resp == nil || resp.Response == nil || resp.StatusCode >= http.StatusInternalServerError
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// limitedStorageService routes storage requests through an apiLimiter.
+type limitedStorageService struct {
+	godo.StorageService
+	limiter *apiLimiter
+}
+
+func (s *limitedStorageService) ListVolumes(ctx context.Context, params *godo.ListVolumeParams) ([]godo.Volume, *godo.Response, error) {
+	var (
+		volumes []godo.Volume
+		resp    *godo.Response
+	)
+	err := s.limiter.do(ctx, true, func() (*godo.Response, error) {
+		var err error
+		volumes, resp, err = s.StorageService.ListVolumes(ctx, params)
+		return resp, err
+	})
+	return volumes, resp, err
+}
+
+func (s *limitedStorageService) GetVolume(ctx context.Context, id string) (*godo.Volume, *godo.Response, error) {
+	var (
+		volume *godo.Volume
+		resp   *godo.Response
+	)
+	err := s.limiter.do(ctx, true, func() (*godo.Response, error) {
+		var err error
+		volume, resp, err = s.StorageService.GetVolume(ctx, id)
+		return resp, err
+	})
+	return volume, resp, err
+}
+
+func (s *limitedStorageService) CreateVolume(ctx context.Context, req *godo.VolumeCreateRequest) (*godo.Volume, *godo.Response, error) {
+	var (
+		volume *godo.Volume
+		resp   *godo.Response
+	)
+	err := s.limiter.do(ctx, false, func() (*godo.Response, error) {
+		var err error
+		volume, resp, err = s.StorageService.CreateVolume(ctx, req)
+		return resp, err
+	})
+	return volume, resp, err
+}
+
+func (s *limitedStorageService) DeleteVolume(ctx context.Context, id string) (*godo.Response, error) {
+	var resp *godo.Response
+	err := s.limiter.do(ctx, false, func() (*godo.Response, error) {
+		var err error
+		resp, err = s.StorageService.DeleteVolume(ctx, id)
+		return resp, err
+	})
+	return resp, err
+}
+
+func (s *limitedStorageService) ListSnapshots(ctx context.Context, volumeID string, opts *godo.ListOptions) ([]godo.Snapshot, *godo.Response, error) {
+	var (
+		snapshots []godo.Snapshot
+		resp      *godo.Response
+	)
+	err := s.limiter.do(ctx, true, func() (*godo.Response, error) {
+		var err error
+		snapshots, resp, err = s.StorageService.ListSnapshots(ctx, volumeID, opts)
+		return resp, err
+	})
+	return snapshots, resp, err
+}
+
+func (s *limitedStorageService) GetSnapshot(ctx context.Context, id string) (*godo.Snapshot, *godo.Response, error) {
+	var (
+		snapshot *godo.Snapshot
+		resp     *godo.Response
+	)
+	err := s.limiter.do(ctx, true, func() (*godo.Response, error) {
+		var err error
+		snapshot, resp, err = s.StorageService.GetSnapshot(ctx, id)
+		return resp, err
+	})
+	return snapshot, resp, err
+}
+
+func (s *limitedStorageService) CreateSnapshot(ctx context.Context, req *godo.SnapshotCreateRequest) (*godo.Snapshot, *godo.Response, error) {
+	var (
+		snapshot *godo.Snapshot
+		resp     *godo.Response
+	)
+	err := s.limiter.do(ctx, false, func() (*godo.Response, error) {
+		var err error
+		snapshot, resp, err = s.StorageService.CreateSnapshot(ctx, req)
+		return resp, err
+	})
+	return snapshot, resp, err
+}
+
+func (s *limitedStorageService) DeleteSnapshot(ctx context.Context, id string) (*godo.Response, error) {
+	var resp *godo.Response
+	err := s.limiter.do(ctx, false, func() (*godo.Response, error) {
+		var err error
+		resp, err = s.StorageService.DeleteSnapshot(ctx, id)
+		return resp, err
+	})
+	return resp, err
+}
+
+// limitedStorageActionsService routes storage action requests through an
+// apiLimiter.
+type limitedStorageActionsService struct {
+	godo.StorageActionsService
+	limiter *apiLimiter
+}
+
+func (s *limitedStorageActionsService) Attach(ctx context.Context, volumeID string, dropletID int) (*godo.Action, *godo.Response, error) {
+	var (
+		action *godo.Action
+		resp   *godo.Response
+	)
+	err := s.limiter.do(ctx, false, func() (*godo.Response, error) {
+		var err error
+		action, resp, err = s.StorageActionsService.Attach(ctx, volumeID, dropletID)
+		return resp, err
+	})
+	return action, resp, err
+}
+
+func (s *limitedStorageActionsService) DetachByDropletID(ctx context.Context, volumeID string, dropletID int) (*godo.Action, *godo.Response, error) {
+	var (
+		action *godo.Action
+		resp   *godo.Response
+	)
+	err := s.limiter.do(ctx, false, func() (*godo.Response, error) {
+		var err error
+		action, resp, err = s.StorageActionsService.DetachByDropletID(ctx, volumeID, dropletID)
+		return resp, err
+	})
+	return action, resp, err
+}
+
+func (s *limitedStorageActionsService) Get(ctx context.Context, volumeID string, actionID int) (*godo.Action, *godo.Response, error) {
+	var (
+		action *godo.Action
+		resp   *godo.Response
+	)
+	err := s.limiter.do(ctx, true, func() (*godo.Response, error) {
+		var err error
+		action, resp, err = s.StorageActionsService.Get(ctx, volumeID, actionID)
+		return resp, err
+	})
+	return action, resp, err
+}
+
+func (s *limitedStorageActionsService) List(ctx context.Context, volumeID string, opt *godo.ListOptions) ([]godo.Action, *godo.Response, error) {
+	var (
+		actions []godo.Action
+		resp    *godo.Response
+	)
+	err := s.limiter.do(ctx, true, func() (*godo.Response, error) {
+		var err error
+		actions, resp, err = s.StorageActionsService.List(ctx, volumeID, opt)
+		return resp, err
+	})
+	return actions, resp, err
+}
+
+func (s *limitedStorageActionsService) Resize(ctx context.Context, volumeID string, sizeGigabytes int, regionSlug string) (*godo.Action, *godo.Response, error) {
+	var (
+		action *godo.Action
+		resp   *godo.Response
+	)
+	err := s.limiter.do(ctx, false, func() (*godo.Response, error) {
+		var err error
+		action, resp, err = s.StorageActionsService.Resize(ctx, volumeID, sizeGigabytes, regionSlug)
+		return resp, err
+	})
+	return action, resp, err
+}
+
+// limitedDropletsService routes droplet requests through an apiLimiter.
+type limitedDropletsService struct {
+	godo.DropletsService
+	limiter *apiLimiter
+}
+
+func (s *limitedDropletsService) Get(ctx context.Context, dropletID int) (*godo.Droplet, *godo.Response, error) {
+	var (
+		droplet *godo.Droplet
+		resp    *godo.Response
+	)
+	err := s.limiter.do(ctx, true, func() (*godo.Response, error) {
+		var err error
+		droplet, resp, err = s.DropletsService.Get(ctx, dropletID)
+		return resp, err
+	})
+	return droplet, resp, err
+}
+
+// limitedSnapshotsService routes snapshot requests through an apiLimiter.
+type limitedSnapshotsService struct {
+	godo.SnapshotsService
+	limiter *apiLimiter
+}
+
+func (s *limitedSnapshotsService) ListVolume(ctx context.Context, opt *godo.ListOptions) ([]godo.Snapshot, *godo.Response, error) {
+	var (
+		snapshots []godo.Snapshot
+		resp      *godo.Response
+	)
+	err := s.limiter.do(ctx, true, func() (*godo.Response, error) {
+		var err error
+		snapshots, resp, err = s.SnapshotsService.ListVolume(ctx, opt)
+		return resp, err
+	})
+	return snapshots, resp, err
+}
+
+func (s *limitedSnapshotsService) Get(ctx context.Context, id string) (*godo.Snapshot, *godo.Response, error) {
+	var (
+		snapshot *godo.Snapshot
+		resp     *godo.Response
+	)
+	err := s.limiter.do(ctx, true, func() (*godo.Response, error) {
+		var err error
+		snapshot, resp, err = s.SnapshotsService.Get(ctx, id)
+		return resp, err
+	})
+	return snapshot, resp, err
+}
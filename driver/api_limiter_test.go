@@ -0,0 +1,201 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+func TestAPILimiterDo(t *testing.T) {
+	newResp := func(code int, header http.Header) *godo.Response {
+		if header == nil {
+			header = http.Header{}
+		}
+		return &godo.Response{Response: &http.Response{StatusCode: code, Header: header}}
+	}
+
+	tests := []struct {
+		name       string
+		idempotent bool
+		// responses are returned by consecutive calls; a nil entry
+		// simulates a request that failed without a response.
+		responses []*godo.Response
+		wantCalls int
+		wantErr   bool
+	}{
+		{
+			name:       "success",
+			idempotent: true,
+			responses:  []*godo.Response{newResp(http.StatusOK, nil)},
+			wantCalls:  1,
+		},
+		{
+			name:       "idempotent request retried on server error",
+			idempotent: true,
+			responses: []*godo.Response{
+				newResp(http.StatusInternalServerError, nil),
+				nil,
+				newResp(http.StatusOK, nil),
+			},
+			wantCalls: 3,
+		},
+		{
+			name:       "non-idempotent request not retried on server error",
+			idempotent: false,
+			responses:  []*godo.Response{newResp(http.StatusInternalServerError, nil)},
+			wantCalls:  1,
+			wantErr:    true,
+		},
+		{
+			name:       "client error not retried",
+			idempotent: true,
+			responses:  []*godo.Response{newResp(http.StatusNotFound, nil)},
+			wantCalls:  1,
+			wantErr:    true,
+		},
+		{
+			name:       "rate limited non-idempotent request retried",
+			idempotent: false,
+			responses: []*godo.Response{
+				newResp(http.StatusTooManyRequests, http.Header{"Retry-After": []string{"0"}}),
+				newResp(http.StatusOK, nil),
+			},
+			wantCalls: 2,
+		},
+		{
+			name:       "retries exhausted",
+			idempotent: true,
+			responses: []*godo.Response{
+				newResp(http.StatusBadGateway, nil),
+				newResp(http.StatusBadGateway, nil),
+				newResp(http.StatusBadGateway, nil),
+				newResp(http.StatusOK, nil),
+			},
+			wantCalls: 3,
+			wantErr:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := newAPILimiter(0, 0, 2, nil)
+			l.baseBackoff = time.Millisecond
+			l.maxBackoff = time.Millisecond
+
+			calls := 0
+			err := l.do(context.Background(), test.idempotent, func() (*godo.Response, error) {
+				resp := test.responses[calls]
+				calls++
+				if resp == nil || resp.StatusCode >= http.StatusBadRequest {
+					return resp, errors.New("request failed")
+				}
+				return resp, nil
+			})
+
+			if calls != test.wantCalls {
+				t.Errorf("got %d calls, want %d", calls, test.wantCalls)
+			}
+			if (err != nil) != test.wantErr {
+				t.Errorf("got error %v, want error: %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestAPILimiterWaitsForRateLimitReset(t *testing.T) {
+	l := newAPILimiter(0, 0, 0, nil)
+
+	l.observe(&godo.Response{
+		Response: &http.Response{StatusCode: http.StatusOK},
+		Rate: godo.Rate{
+			Limit:     5000,
+			Remaining: 0,
+			Reset:     godo.Timestamp{Time: time.Now().Add(time.Hour)},
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	called := false
+	err := l.do(ctx, true, func() (*godo.Response, error) {
+		called = true
+		return nil, nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if called {
+		t.Error("request was sent although the rate limit was exhausted")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		reset  time.Time
+		min    time.Duration
+		max    time.Duration
+	}{
+		{
+			name:   "seconds",
+			header: "7",
+			min:    7 * time.Second,
+			max:    7 * time.Second,
+		},
+		{
+			name:   "HTTP date",
+			header: time.Now().Add(time.Minute).UTC().Format(http.TimeFormat),
+			min:    58 * time.Second,
+			max:    time.Minute,
+		},
+		{
+			name:  "rate limit reset",
+			reset: time.Now().Add(time.Minute),
+			min:   59 * time.Second,
+			max:   time.Minute,
+		},
+		{
+			name: "unknown",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := http.Header{}
+			if test.header != "" {
+				header.Set("Retry-After", test.header)
+			}
+			resp := &godo.Response{
+				Response: &http.Response{StatusCode: http.StatusTooManyRequests, Header: header},
+				Rate:     godo.Rate{Reset: godo.Timestamp{Time: test.reset}},
+			}
+
+			got := retryAfter(resp)
+			if got < test.min || got > test.max {
+				t.Errorf("got %s, want between %s and %s", got, test.min, test.max)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"k8s.io/mount-utils"
+)
+
+const (
+	// DefaultMaxVolumesPerNode is the number of volumes that can be attached
+	// to a single droplet. The limit is the same for all droplets, and the
+	// droplet metadata exposes neither the limit nor the attached volumes, so
+	// it can only be overridden through --max-volumes-per-node.
+	// See: https://www.digitalocean.com/docs/volumes/overview/#limits
+	DefaultMaxVolumesPerNode = 7
+
+	// kubeletDir is the directory below which the kubelet mounts the volumes
+	// managed through CSI.
+	kubeletDir = "/var/lib/kubelet"
+
+	// kubeletCSIPluginDir is the directory below kubeletDir in which the
+	// kubelet records the CSI volumes it stages.
+	kubeletCSIPluginDir = "plugins/kubernetes.io/csi"
+
+	// kubeletVolumeDataFile is the name of the files the kubelet records the
+	// driver and handle of a CSI volume in.
+	kubeletVolumeDataFile = "vol_data.json"
+
+	// csiVolumeNamePrefix is the prefix of the names of dynamically
+	// provisioned volumes (i.e. the default --volume-name-prefix of the
+	// external-provisioner).
+	csiVolumeNamePrefix = "pvc-"
+)
+
+// partitionLinkSuffix matches the suffix of the by-id links of partitions.
+var partitionLinkSuffix = regexp.MustCompile(`-part[0-9]+$`)
+
+// countUnmanagedVolumes returns the number of DigitalOcean volumes linked in
+// the given by-id directory that are not managed through CSI, e.g. because
+// they were attached to the droplet manually. A volume is considered managed
+// if any of its devices (the disk, its partitions or the device mapper
+// targets on top of them) is mounted below managedRoot, if it has been
+// dynamically provisioned, or if the identifiers of its disk carry one of the
+// given IDs of the volumes known to the CO.
+func countUnmanagedVolumes(byIDDir string, mountInfos []mount.MountInfo, managedRoot string, volumeIDs []string) (int, error) {
+	links, err := filepath.Glob(filepath.Join(byIDDir, diskDOPrefix+"*"))
+	if err != nil {
+		return 0, err
+	}
+
+	managedDevices := map[string]bool{}
+	for _, mi := range mountInfos {
+		if mi.MountPoint != managedRoot && !strings.HasPrefix(mi.MountPoint, managedRoot+"/") {
+			continue
+		}
+		managedDevices[mountedDeviceName(mi)] = true
+	}
+
+	unmanaged := 0
+	for _, link := range links {
+		volumeName := strings.TrimPrefix(filepath.Base(link), diskDOPrefix)
+		if partitionLinkSuffix.MatchString(volumeName) {
+			// partitions are accounted for with their disk
+			continue
+		}
+		if strings.HasPrefix(volumeName, csiVolumeNamePrefix) {
+			continue
+		}
+
+		device, err := filepath.EvalSymlinks(link)
+		if err != nil {
+			// dangling links belong to volumes that are being detached
+			continue
+		}
+
+		devices, err := getRelatedDevices(filepath.Base(device))
+		if err != nil {
+			return 0, err
+		}
+
+		managed := false
+		for _, name := range devices {
+			if managedDevices[name] {
+				managed = true
+				break
+			}
+		}
+		if managed {
+			continue
+		}
+
+		if len(volumeIDs) > 0 {
+			identifiers, err := getDeviceIdentifiers(link)
+			if err != nil {
+				return 0, err
+			}
+			for _, volumeID := range volumeIDs {
+				if matchDeviceIdentifiers(identifiers, volumeID) {
+					managed = true
+					break
+				}
+			}
+		}
+		if !managed {
+			unmanaged++
+		}
+	}
+
+	return unmanaged, nil
+}
+
+// kubeletCSIVolumeIDs returns the handles of the volumes of the given driver
+// that the kubelet recorded below the given directory. The kubelet records a
+// volume before it asks the driver to stage it, so volumes that are attached
+// through CSI are known even if staging has not completed yet.
+func kubeletCSIVolumeIDs(pluginDir, driverName string) ([]string, error) {
+	var volumeIDs []string
+	err := filepath.Walk(pluginDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		// mounted volumes are accounted for through the mount table, and
+		// walking through them could take long
+		if info.IsDir() && info.Name() == "globalmount" {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || info.Name() != kubeletVolumeDataFile {
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var volData struct {
+			DriverName   string `json:"driverName"`
+			VolumeHandle string `json:"volumeHandle"`
+		}
+		if err := json.Unmarshal(data, &volData); err != nil {
+			// the kubelet may be writing the file right now
+			return nil
+		}
+		if volData.DriverName == driverName && volData.VolumeHandle != "" {
+			volumeIDs = append(volumeIDs, volData.VolumeHandle)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return volumeIDs, nil
+}
+
+// mountedDeviceName returns the kernel name of the block device that is
+// mounted by the given mount.
+func mountedDeviceName(mi mount.MountInfo) string {
+	// raw block volumes are bind mounts of a device file
+	if mi.FsType == "devtmpfs" {
+		return filepath.Base(mi.Root)
+	}
+
+	source := mi.Source
+	if resolved, err := filepath.EvalSymlinks(source); err == nil {
+		source = resolved
+	}
+	return filepath.Base(source)
+}
+
+// getRelatedDevices returns the kernel names of the given disk, its
+// partitions and the device mapper targets stacked on top of them.
+func getRelatedDevices(disk string) ([]string, error) {
+	devices := []string{disk}
+	for i := 0; i < len(devices); i++ {
+		sysPath := filepath.Join(sysClassBlockPath, devices[i])
+
+		for _, dir := range []string{"", "holders"} {
+			entries, err := ioutil.ReadDir(filepath.Join(sysPath, dir))
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return nil, err
+			}
+
+			for _, entry := range entries {
+				if dir == "" {
+					if _, err := os.Stat(filepath.Join(sysPath, entry.Name(), "partition")); err != nil {
+						continue
+					}
+				}
+				devices = append(devices, entry.Name())
+			}
+		}
+	}
+	return devices, nil
+}
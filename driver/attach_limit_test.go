@@ -0,0 +1,143 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s.io/mount-utils"
+)
+
+func TestCountUnmanagedVolumes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "attach-limit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(path string) { sysClassBlockPath = path }(sysClassBlockPath)
+	sysClassBlockPath = filepath.Join(dir, "sys")
+
+	byIDDir := filepath.Join(dir, "by-id")
+	devDir := filepath.Join(dir, "dev")
+	for _, path := range []string{
+		byIDDir,
+		devDir,
+		filepath.Join(sysClassBlockPath, "sdb"),
+		filepath.Join(sysClassBlockPath, "sdc"),
+		filepath.Join(sysClassBlockPath, "sdd"),
+		filepath.Join(sysClassBlockPath, "sde", "sde1"),
+		filepath.Join(sysClassBlockPath, "sde1", "holders", "dm-0"),
+		filepath.Join(sysClassBlockPath, "sdf"),
+		filepath.Join(sysClassBlockPath, "sdh", "device"),
+		filepath.Join(sysClassBlockPath, "sdi", "device"),
+	} {
+		if err := os.MkdirAll(path, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(sysClassBlockPath, "sde", "sde1", "partition"), []byte("1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for device, serial := range map[string]string{
+		"sdh": "vol-static-unstaged",
+		"sdi": "vol-other-driver",
+	} {
+		page := append([]byte{0x00, 0x80, 0x00, byte(len(serial))}, serial...)
+		if err := ioutil.WriteFile(filepath.Join(sysClassBlockPath, device, "device", "vpd_pg80"), page, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, device := range map[string]string{
+		// dynamically provisioned and not staged yet
+		"pvc-0123": "sda",
+		// attached manually and not mounted
+		"manual": "sdb",
+		// statically provisioned filesystem volume
+		"static-fs": "sdc",
+		// statically provisioned raw block volume
+		"static-block": "sdd",
+		// statically provisioned, encrypted partition
+		"static-luks":       "sde",
+		"static-luks-part1": "sde1",
+		// attached manually and mounted outside of the kubelet directory
+		"mounted": "sdf",
+		// being detached
+		"dangling": "sdg",
+		// statically provisioned and not staged yet
+		"static-unstaged": "sdh",
+		// managed by a different driver and not staged yet
+		"other-driver": "sdi",
+	} {
+		devicePath := filepath.Join(devDir, device)
+		if device != "sdg" {
+			if err := ioutil.WriteFile(devicePath, nil, 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.Symlink(devicePath, filepath.Join(byIDDir, diskDOPrefix+name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mountInfos := []mount.MountInfo{
+		{Source: "/dev/vda1", FsType: "ext4", Root: "/", MountPoint: "/"},
+		{Source: "/dev/sdc", FsType: "ext4", Root: "/", MountPoint: "/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pv-1/globalmount"},
+		{Source: "udev", FsType: "devtmpfs", Root: "/sdd", MountPoint: "/var/lib/kubelet/plugins/kubernetes.io/csi/volumeDevices/publish/pv-2/pod"},
+		{Source: "/dev/dm-0", FsType: "ext4", Root: "/", MountPoint: "/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pv-3/globalmount"},
+		{Source: "/dev/sdf", FsType: "ext4", Root: "/", MountPoint: "/mnt/volume_nyc1_01"},
+		// a directory that merely shares the prefix of the kubelet directory
+		{Source: "/dev/sdb", FsType: "ext4", Root: "/", MountPoint: "/var/lib/kubelet-backup"},
+	}
+
+	pluginDir := filepath.Join(dir, "plugins")
+	for path, data := range map[string]string{
+		filepath.Join(pluginDir, DefaultDriverName, "0123abcd", "vol_data.json"):   `{"driverName":"` + DefaultDriverName + `","volumeHandle":"vol-static-unstaged","specVolID":"pv-4"}`,
+		filepath.Join(pluginDir, "pv", "pv-5", "vol_data.json"):                    `{"driverName":"other.csi.example.com","volumeHandle":"vol-other-driver","specVolID":"pv-5"}`,
+		filepath.Join(pluginDir, "volumeDevices", "pv-6", "data", "vol_data.json"): `{"driverName":"`,
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	volumeIDs, err := kubeletCSIVolumeIDs(pluginDir, DefaultDriverName)
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	if want := []string{"vol-static-unstaged"}; !reflect.DeepEqual(volumeIDs, want) {
+		t.Errorf("got volume IDs %q, want %q", volumeIDs, want)
+	}
+
+	got, err := countUnmanagedVolumes(byIDDir, mountInfos, kubeletDir, volumeIDs)
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+
+	// manual, mounted and other-driver
+	if want := 3; got != want {
+		t.Errorf("got %d unmanaged volumes, want %d", got, want)
+	}
+}
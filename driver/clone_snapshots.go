@@ -0,0 +1,123 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// cloneSnapshotSweepInterval is the interval in which the controller
+	// removes abandoned clone snapshots.
+	cloneSnapshotSweepInterval = time.Hour
+
+	// cloneSnapshotMaxAge is the age after which a clone snapshot that has
+	// been taken completely is considered abandoned. CreateVolume deletes the
+	// snapshot as soon as the clone has been created, which the provisioner
+	// retries well within this period unless the PVC has been deleted.
+	cloneSnapshotMaxAge = time.Hour
+)
+
+// runCloneSnapshotSweeper removes abandoned clone snapshots periodically
+// until the given context is done. Nothing is swept if no tag is configured
+// since the clone snapshots of the driver cannot be told apart from those of
+// other clusters then.
+func (d *Driver) runCloneSnapshotSweeper(ctx context.Context) {
+	log := d.log.WithField("method", "sweep_clone_snapshots")
+
+	if d.doTag == "" {
+		log.Info("no tag configured, abandoned clone snapshots are not swept")
+		return
+	}
+
+	ticker := time.NewTicker(cloneSnapshotSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := d.sweepCloneSnapshots(ctx, log, time.Now()); err != nil {
+			log.WithError(err).Warn("failed to sweep clone snapshots")
+		}
+	}
+}
+
+// sweepCloneSnapshots deletes the transient snapshots taken for cloning
+// volumes that have been left behind, e.g. because the PVC was deleted before
+// the clone could be created. Only snapshots tagged with the tag of the driver
+// are considered, regardless of --list-unowned-resources, so that the clone
+// snapshots of other clusters are never deleted. Snapshots that are younger
+// than cloneSnapshotMaxAge or still being taken are kept.
+func (d *Driver) sweepCloneSnapshots(ctx context.Context, log *logrus.Entry, now time.Time) error {
+	if d.doTag == "" {
+		return nil
+	}
+
+	untypedSnapshots, _, err := listResources(ctx, log, 0, 0, d.snapshotLister())
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	readiness := d.newSnapshotReadiness()
+	for _, untypedSnapshot := range untypedSnapshots {
+		snap := untypedSnapshot.(godo.Snapshot)
+		if !strings.HasPrefix(snap.Name, cloneSnapshotPrefix) || !containsString(snap.Tags, d.doTag) {
+			continue
+		}
+
+		snapLog := log.WithFields(logrus.Fields{
+			"clone_snapshot_id":   snap.ID,
+			"clone_snapshot_name": snap.Name,
+		})
+
+		created, err := time.Parse(time.RFC3339, snap.Created)
+		if err != nil {
+			snapLog.WithError(err).Warn("couldn't parse snapshot's created field")
+			continue
+		}
+		if now.Sub(created) < cloneSnapshotMaxAge {
+			continue
+		}
+
+		ready, err := readiness.ready(ctx, &snap)
+		if err != nil {
+			return err
+		}
+		if !ready {
+			continue
+		}
+
+		resp, err := d.storage.DeleteSnapshot(ctx, snap.ID)
+		if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+			return fmt.Errorf("failed to delete clone snapshot %s: %s", snap.ID, err)
+		}
+		snapLog.Info("abandoned clone snapshot deleted")
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/sirupsen/logrus"
+)
+
+func TestSweepCloneSnapshots(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+
+	snapshot := func(id, name, volumeID string, age time.Duration, tags ...string) *godo.Snapshot {
+		return &godo.Snapshot{
+			ID:         id,
+			Name:       name,
+			ResourceID: volumeID,
+			Created:    now.Add(-age).Format(time.RFC3339),
+			Tags:       tags,
+		}
+	}
+
+	tests := []struct {
+		name                 string
+		doTag                string
+		listUnownedResources bool
+		wantDeleted          []string
+	}{
+		{
+			name:        "tag configured",
+			doTag:       "k8s:cluster-id",
+			wantDeleted: []string{"abandoned"},
+		},
+		{
+			name:                 "unowned resources listed",
+			doTag:                "k8s:cluster-id",
+			listUnownedResources: true,
+			wantDeleted:          []string{"abandoned"},
+		},
+		{
+			name: "no tag configured",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			snapshots := map[string]*godo.Snapshot{
+				"abandoned":   snapshot("abandoned", cloneSnapshotName("pvc-1"), "volume-1", 2*cloneSnapshotMaxAge, "k8s:cluster-id"),
+				"recent":      snapshot("recent", cloneSnapshotName("pvc-2"), "volume-1", cloneSnapshotMaxAge/2, "k8s:cluster-id"),
+				"in-progress": snapshot("in-progress", cloneSnapshotName("pvc-3"), "volume-2", 2*cloneSnapshotMaxAge, "k8s:cluster-id"),
+				"regular":     snapshot("regular", "snapshot-1", "volume-1", 2*cloneSnapshotMaxAge, "k8s:cluster-id"),
+				"unowned":     snapshot("unowned", cloneSnapshotName("pvc-4"), "volume-1", 2*cloneSnapshotMaxAge),
+				"other":       snapshot("other", cloneSnapshotName("pvc-5"), "volume-1", 2*cloneSnapshotMaxAge, "k8s:other-cluster-id"),
+			}
+
+			d := &Driver{
+				doTag:                test.doTag,
+				listUnownedResources: test.listUnownedResources,
+				storage: &fakeStorageDriver{
+					snapshots: snapshots,
+				},
+				storageActions: &fakeStorageActionsDriver{
+					actions: map[string][]godo.Action{
+						"volume-2": {
+							{
+								Type:      "snapshot",
+								Status:    godo.ActionInProgress,
+								StartedAt: &godo.Timestamp{Time: now.Add(-3 * cloneSnapshotMaxAge)},
+							},
+						},
+					},
+				},
+				snapshots: &fakeSnapshotsDriver{
+					snapshots: snapshots,
+				},
+				log: logrus.New().WithField("test_enabed", true),
+			}
+
+			if err := d.sweepCloneSnapshots(context.Background(), d.log, now); err != nil {
+				t.Fatalf("got error: %s", err)
+			}
+
+			deleted := map[string]bool{}
+			for _, id := range test.wantDeleted {
+				deleted[id] = true
+			}
+			for _, id := range []string{"abandoned", "recent", "in-progress", "regular", "unowned", "other"} {
+				_, ok := snapshots[id]
+				switch {
+				case deleted[id] && ok:
+					t.Errorf("got snapshot %q kept, want it deleted", id)
+				case !deleted[id] && !ok:
+					t.Errorf("got snapshot %q deleted, want it kept", id)
+				}
+			}
+		})
+	}
+}
@@ -20,7 +20,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -66,21 +68,40 @@ const (
 	// createdByDO is used to tag volumes that are created by this CSI plugin
 	createdByDO = "Created by DigitalOcean CSI driver"
 
+	// cloneSnapshotPrefix prefixes the names of the transient snapshots taken
+	// to clone volumes
+	cloneSnapshotPrefix = "csi-clone-"
+
 	// doAPITimeout sets the timeout we will use when communicating with the
 	// Digital Ocean API. NOTE: some queries inherit the context timeout
 	doAPITimeout = 10 * time.Second
 
-	// maxVolumesPerDropletErrorMessage is the error message returned by the DO
-	// API when the per-droplet volume limit would be exceeded.
-	maxVolumesPerDropletErrorMessage = "cannot attach more than 7 volumes to a single Droplet"
+	// actionErrored is the status of a failed action. godo only defines the
+	// in-progress and completed states.
+	actionErrored = "errored"
+
+	// stuckActionThreshold is the duration after which an in-progress volume
+	// action is considered to be stuck and the volume reported as abnormal.
+	stuckActionThreshold = 5 * time.Minute
 )
 
 var (
-	// DO currently only support a single node to be attached to a single node
-	// in read/write mode. This corresponds to `accessModes.ReadWriteOnce` in a
-	// PVC resource on Kubernetes
-	supportedAccessMode = &csi.VolumeCapability_AccessMode{
-		Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+	// maxVolumesPerDropletError matches the error message returned by the DO
+	// API when the per-droplet volume limit would be exceeded.
+	maxVolumesPerDropletError = regexp.MustCompile(`cannot attach more than [0-9]+ volumes to a single Droplet`)
+
+	// DO currently only support a single node to be attached to a single node.
+	// SINGLE_NODE_WRITER corresponds to `accessModes.ReadWriteOnce` in a PVC
+	// resource on Kubernetes and SINGLE_NODE_READER_ONLY to a read-only
+	// publication on a single node. Since the SINGLE_NODE_MULTI_WRITER
+	// capability is announced, COs request SINGLE_NODE_MULTI_WRITER for
+	// `accessModes.ReadWriteOnce` and SINGLE_NODE_SINGLE_WRITER for
+	// `accessModes.ReadWriteOncePod` instead.
+	supportedAccessModes = map[csi.VolumeCapability_AccessMode_Mode]bool{
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER:        true,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY:   true,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER: true,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER:  true,
 	}
 )
 
@@ -99,22 +120,75 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("volume capabilities cannot be satisified: %s", strings.Join(violations, "; ")))
 	}
 
+	mkfsOptions := req.Parameters[MkfsOptionsAttribute]
+	if err := validateMkfsOptions(req.VolumeCapabilities, mkfsOptions); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if _, err := allowForeignSignatures(req.Parameters); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := validateLuksParameters(req.Name, req.Parameters); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid luks parameters: %s", err)
+	}
+
+	description, tags, err := renderMetadata(req.Parameters, volumeMetadataKeys)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
 	size, err := extractStorage(req.CapacityRange)
 	if err != nil {
 		return nil, status.Errorf(codes.OutOfRange, "invalid capacity range: %v", err)
 	}
 
-	if req.AccessibilityRequirements != nil {
-		for _, t := range req.AccessibilityRequirements.Requisite {
-			region, ok := t.Segments["region"]
-			if !ok {
-				continue // nothing to do
+	// volumes can only be created in the region of their source
+	var sourceRegions []string
+	var snapshotID, sourceVolumeID string
+	contentSource := req.GetVolumeContentSource()
+	if contentSource != nil && contentSource.GetSnapshot() != nil {
+		snapshotID = contentSource.GetSnapshot().GetSnapshotId()
+		if snapshotID == "" {
+			return nil, status.Error(codes.InvalidArgument, "snapshot ID is empty")
+		}
+
+		// check if the snapshot exist before we continue
+		snapshot, resp, err := d.snapshots.Get(ctx, snapshotID)
+		if err != nil {
+			if resp != nil && resp.StatusCode == http.StatusNotFound {
+				return nil, status.Errorf(codes.NotFound, "snapshot %q does not exist", snapshotID)
 			}
+			return nil, err
+		}
+		sourceRegions = snapshot.Regions
+	}
+
+	if contentSource != nil && contentSource.GetVolume() != nil {
+		sourceVolumeID = contentSource.GetVolume().GetVolumeId()
+		if sourceVolumeID == "" {
+			return nil, status.Error(codes.InvalidArgument, "source volume ID is empty")
+		}
 
-			if region != d.region {
-				return nil, status.Errorf(codes.ResourceExhausted, "volume can be only created in region: %q, got: %q", d.region, region)
+		// check if the source volume exist before we continue
+		sourceVol, resp, err := d.storage.GetVolume(ctx, sourceVolumeID)
+		if err != nil {
+			if resp != nil && resp.StatusCode == http.StatusNotFound {
+				return nil, status.Errorf(codes.NotFound, "source volume %q does not exist", sourceVolumeID)
 			}
+			return nil, err
 		}
+
+		if sourceVol.SizeGigaBytes*giB > size {
+			return nil, status.Errorf(codes.OutOfRange, "requested size %v is smaller than the size of source volume %q (%v)",
+				formatBytes(size), sourceVolumeID, formatBytes(sourceVol.SizeGigaBytes*giB))
+		}
+		sourceRegions = []string{d.volumeRegion(sourceVol)}
+	}
+
+	region, err := d.selectRegion(req.AccessibilityRequirements, sourceRegions)
+	if err != nil {
+		return nil, err
 	}
 
 	volumeName := req.Name
@@ -127,6 +201,7 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		"volume_name":             volumeName,
 		"storage_size_giga_bytes": size / giB,
 		"method":                  "create_volume",
+		"region":                  region,
 		"volume_capabilities":     req.VolumeCapabilities,
 		"luks_encrypted":          luksEncrypted,
 	})
@@ -134,7 +209,7 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 
 	// get volume first, if it's created do no thing
 	volumes, _, err := d.storage.ListVolumes(ctx, &godo.ListVolumeParams{
-		Region: d.region,
+		Region: region,
 		Name:   volumeName,
 	})
 	if err != nil {
@@ -144,9 +219,7 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	csiVolume := csi.Volume{
 		AccessibleTopology: []*csi.Topology{
 			{
-				Segments: map[string]string{
-					"region": d.region,
-				},
+				Segments: d.topologySegments(region),
 			},
 		},
 		CapacityBytes: size,
@@ -159,6 +232,28 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	if luksEncrypted == "true" {
 		csiVolume.VolumeContext[LuksCipherAttribute] = req.Parameters[LuksCipherAttribute]
 		csiVolume.VolumeContext[LuksKeySizeAttribute] = req.Parameters[LuksKeySizeAttribute]
+		// optional settings are recorded only if set so that volumes keep
+		// using the defaults of cryptsetup otherwise
+		for _, attr := range []string{
+			LuksTypeAttribute,
+			LuksPbkdfAttribute,
+			LuksPbkdfMemoryAttribute,
+			LuksPbkdfIterationsAttribute,
+			LuksSectorSizeAttribute,
+			LuksIntegrityAttribute,
+		} {
+			if value := req.Parameters[attr]; value != "" {
+				csiVolume.VolumeContext[attr] = value
+			}
+		}
+	}
+
+	if mkfsOptions != "" {
+		csiVolume.VolumeContext[MkfsOptionsAttribute] = mkfsOptions
+	}
+
+	if value, ok := req.Parameters[AllowForeignSignaturesAttribute]; ok {
+		csiVolume.VolumeContext[AllowForeignSignaturesAttribute] = value
 	}
 
 	// volume already exist, do nothing
@@ -172,6 +267,15 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 			return nil, status.Error(codes.AlreadyExists, fmt.Sprintf("invalid option requested size: %d", size))
 		}
 
+		// a previous clone attempt may have failed after the volume got
+		// created but before the transient snapshot was cleaned up
+		if sourceVolume := req.GetVolumeContentSource().GetVolume(); sourceVolume != nil {
+			if err := d.deleteCloneSnapshot(ctx, log, sourceVolume.GetVolumeId(), volumeName); err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to clean up clone snapshot: %s", err)
+			}
+			csiVolume.ContentSource = req.GetVolumeContentSource()
+		}
+
 		log.Info("volume already created")
 		csiVolume.VolumeId = vol.ID
 		csiVolume.CapacityBytes = vol.SizeGigaBytes * giB
@@ -179,38 +283,25 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	}
 
 	volumeReq := &godo.VolumeCreateRequest{
-		Region:        d.region,
+		Region:        region,
 		Name:          volumeName,
-		Description:   createdByDO,
+		Description:   description,
 		SizeGigaBytes: size / giB,
+		Tags:          appendTag(tags, d.doTag),
 	}
 
-	if d.doTag != "" {
-		volumeReq.Tags = append(volumeReq.Tags, d.doTag)
-	}
-
-	contentSource := req.GetVolumeContentSource()
-	if contentSource != nil && contentSource.GetSnapshot() != nil {
-		snapshotID := contentSource.GetSnapshot().GetSnapshotId()
-		if snapshotID == "" {
-			return nil, status.Error(codes.InvalidArgument, "snapshot ID is empty")
-		}
-
-		// check if the snapshot exist before we continue
-		_, resp, err := d.snapshots.Get(ctx, snapshotID)
-		if err != nil {
-			if resp != nil && resp.StatusCode == http.StatusNotFound {
-				return nil, status.Errorf(codes.NotFound, "snapshot %q does not exist", snapshotID)
-			}
-			return nil, err
-		}
-
+	if snapshotID != "" {
 		log.WithField("snapshot_id", snapshotID).Info("using snapshot as volume source")
 		volumeReq.SnapshotID = snapshotID
 	}
 
+	if sourceVolumeID != "" {
+		log = log.WithField("source_volume_id", sourceVolumeID)
+		log.Info("using volume as volume source")
+	}
+
 	log.Info("checking volume limit")
-	details, err := d.checkLimit(ctx)
+	details, err := d.checkLimit(ctx, region)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to check volume limit: %s", err)
 	}
@@ -220,6 +311,24 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 			details.limit, details.numVolumes)
 	}
 
+	if sourceVolumeID != "" {
+		snap, err := d.createCloneSnapshot(ctx, log, sourceVolumeID, volumeName)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to snapshot source volume %q: %s", sourceVolumeID, err)
+		}
+
+		ready, err := d.newSnapshotReadiness().ready(ctx, snap)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to check clone snapshot %q: %s", snap.ID, err)
+		}
+		if !ready {
+			// the retried call finds the same clone snapshot again
+			log.WithField("clone_snapshot_id", snap.ID).Info("clone snapshot is not ready yet")
+			return nil, status.Errorf(codes.Unavailable, "snapshot %q of source volume %q is not ready yet", snap.ID, sourceVolumeID)
+		}
+		volumeReq.SnapshotID = snap.ID
+	}
+
 	log.WithField("volume_req", volumeReq).Info("creating volume")
 	vol, _, err := d.storage.CreateVolume(ctx, volumeReq)
 	if err != nil {
@@ -232,7 +341,21 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	// external-provisioner expects a content source to be returned if the PVC
 	// specified a data source, which corresponds to us having received a
 	// content source field in the CreateVolume request.
-	if volumeReq.SnapshotID != "" {
+	switch {
+	case sourceVolumeID != "":
+		resp.Volume.ContentSource = &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Volume{
+				Volume: &csi.VolumeContentSource_VolumeSource{
+					VolumeId: sourceVolumeID,
+				},
+			},
+		}
+
+		// the snapshot is only needed to seed the new volume
+		if err := d.deleteCloneSnapshot(ctx, log, sourceVolumeID, volumeName); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to clean up clone snapshot: %s", err)
+		}
+	case volumeReq.SnapshotID != "":
 		resp.Volume.ContentSource = &csi.VolumeContentSource{
 			Type: &csi.VolumeContentSource_Snapshot{
 				Snapshot: &csi.VolumeContentSource_SnapshotSource{
@@ -294,14 +417,6 @@ func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.Controlle
 		return nil, status.Errorf(codes.InvalidArgument, "ControllerPublishVolume Node ID %q cannot be converted to integer: %s", req.NodeId, err)
 	}
 
-	if req.Readonly {
-		// TODO(arslan): we should return codes.InvalidArgument, but the CSI
-		// test fails, because according to the CSI Spec, this flag cannot be
-		// changed on the same volume. However we don't use this flag at all,
-		// as there are no `readonly` attachable volumes.
-		return nil, status.Error(codes.AlreadyExists, "read only Volumes are not supported")
-	}
-
 	log := d.log.WithFields(logrus.Fields{
 		"volume_id":  req.VolumeId,
 		"node_id":    req.NodeId,
@@ -341,14 +456,7 @@ func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.Controlle
 		attachedID = id
 		if id == dropletID {
 			log.Info("volume is already attached")
-			return &csi.ControllerPublishVolumeResponse{
-				PublishContext: map[string]string{
-					d.publishInfoVolumeName: vol.Name,
-					LuksEncryptedAttribute:  req.VolumeContext[LuksEncryptedAttribute],
-					LuksCipherAttribute:     req.VolumeContext[LuksCipherAttribute],
-					LuksKeySizeAttribute:    req.VolumeContext[LuksKeySizeAttribute],
-				},
-			}, nil
+			return d.publishVolumeResponse(vol, req), nil
 		}
 	}
 
@@ -359,6 +467,23 @@ func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.Controlle
 			req.VolumeId, attachedID)
 	}
 
+	unlock, err := d.lockDroplet(ctx, log, dropletID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// a previous request may have timed out while the volume was being
+	// attached, in which case attaching again would be rejected
+	attached, err := d.resumeVolumeAction(ctx, log, vol, dropletID)
+	if err != nil {
+		return nil, err
+	}
+	if attached {
+		log.Info("volume was attached")
+		return d.publishVolumeResponse(vol, req), nil
+	}
+
 	// attach the volume to the correct node
 	action, resp, err := d.storageActions.Attach(ctx, req.VolumeId, dropletID)
 	if err != nil {
@@ -369,17 +494,22 @@ func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.Controlle
 					"error": err,
 					"resp":  resp,
 				}).Warn("assuming volume is attached because of error response")
-				return &csi.ControllerPublishVolumeResponse{
-					PublishContext: map[string]string{
-						d.publishInfoVolumeName: vol.Name,
-						LuksEncryptedAttribute:  req.VolumeContext[LuksEncryptedAttribute],
-						LuksCipherAttribute:     req.VolumeContext[LuksCipherAttribute],
-						LuksKeySizeAttribute:    req.VolumeContext[LuksKeySizeAttribute],
-					},
-				}, nil
+				return d.publishVolumeResponse(vol, req), nil
 			}
 
 			if strings.Contains(err.Error(), "Droplet already has a pending event") {
+				// the pending action may be one of the volume that is no
+				// longer tracked, e.g., because the controller restarted
+				// while waiting on it
+				resumed, attached, rerr := d.resumeListedAction(ctx, log, req.VolumeId, dropletID)
+				if rerr != nil {
+					return nil, rerr
+				}
+				if resumed && attached {
+					log.Info("volume was attached")
+					return d.publishVolumeResponse(vol, req), nil
+				}
+
 				log.WithFields(logrus.Fields{
 					"error": err,
 					"resp":  resp,
@@ -388,7 +518,7 @@ func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.Controlle
 				return nil, status.Errorf(codes.Aborted, "cannot attach because droplet %d has pending action for volume %q", dropletID, req.VolumeId)
 			}
 
-			if strings.Contains(err.Error(), maxVolumesPerDropletErrorMessage) {
+			if maxVolumesPerDropletError.MatchString(err.Error()) {
 				return nil, status.Errorf(codes.ResourceExhausted, err.Error())
 			}
 		}
@@ -396,6 +526,7 @@ func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.Controlle
 	}
 
 	if action != nil {
+		d.trackAction(log, req.VolumeId, dropletID, action)
 		log.Info("waiting until volume is attached")
 		if err := d.waitAction(ctx, log, req.VolumeId, action.ID); err != nil {
 			return nil, err
@@ -403,14 +534,20 @@ func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.Controlle
 	}
 
 	log.Info("volume was attached")
+	return d.publishVolumeResponse(vol, req), nil
+}
+
+// publishVolumeResponse returns the response for a volume published to a node.
+func (d *Driver) publishVolumeResponse(vol *godo.Volume, req *csi.ControllerPublishVolumeRequest) *csi.ControllerPublishVolumeResponse {
 	return &csi.ControllerPublishVolumeResponse{
 		PublishContext: map[string]string{
 			d.publishInfoVolumeName: vol.Name,
+			d.publishInfoVolumeID:   vol.ID,
 			LuksEncryptedAttribute:  req.VolumeContext[LuksEncryptedAttribute],
 			LuksCipherAttribute:     req.VolumeContext[LuksCipherAttribute],
 			LuksKeySizeAttribute:    req.VolumeContext[LuksKeySizeAttribute],
 		},
-	}, nil
+	}
 }
 
 // ControllerUnpublishVolume deattaches the given volume from the node
@@ -433,7 +570,7 @@ func (d *Driver) ControllerUnpublishVolume(ctx context.Context, req *csi.Control
 	log.Info("controller unpublish volume called")
 
 	// check if volume exist before trying to detach it
-	_, resp, err := d.storage.GetVolume(ctx, req.VolumeId)
+	vol, resp, err := d.storage.GetVolume(ctx, req.VolumeId)
 	if err != nil {
 		if resp != nil && resp.StatusCode == http.StatusNotFound {
 			log.Info("assuming volume is detached because it does not exist")
@@ -452,6 +589,23 @@ func (d *Driver) ControllerUnpublishVolume(ctx context.Context, req *csi.Control
 		return nil, err
 	}
 
+	unlock, err := d.lockDroplet(ctx, log, dropletID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// a previous request may have timed out while the volume was being
+	// detached, in which case detaching again would be rejected
+	attached, err := d.resumeVolumeAction(ctx, log, vol, dropletID)
+	if err != nil {
+		return nil, err
+	}
+	if !attached {
+		log.Info("volume was detached")
+		return &csi.ControllerUnpublishVolumeResponse{}, nil
+	}
+
 	action, resp, err := d.storageActions.DetachByDropletID(ctx, req.VolumeId, dropletID)
 	if err != nil {
 		if resp != nil {
@@ -473,6 +627,18 @@ func (d *Driver) ControllerUnpublishVolume(ctx context.Context, req *csi.Control
 				}
 
 				if strings.Contains(err.Error(), "Droplet already has a pending event") {
+					// the pending action may be one of the volume that is
+					// no longer tracked, e.g., because the controller
+					// restarted while waiting on it
+					resumed, attached, rerr := d.resumeListedAction(ctx, log, req.VolumeId, dropletID)
+					if rerr != nil {
+						return nil, rerr
+					}
+					if resumed && !attached {
+						log.Info("volume was detached")
+						return &csi.ControllerUnpublishVolumeResponse{}, nil
+					}
+
 					log.WithFields(logrus.Fields{
 						"error": err,
 						"resp":  resp,
@@ -487,6 +653,7 @@ func (d *Driver) ControllerUnpublishVolume(ctx context.Context, req *csi.Control
 	}
 
 	if action != nil {
+		d.trackAction(log, req.VolumeId, dropletID, action)
 		log.Info("waiting until volume is detached")
 		if err := d.waitAction(ctx, log, req.VolumeId, action.ID); err != nil {
 			return nil, err
@@ -509,10 +676,9 @@ func (d *Driver) ValidateVolumeCapabilities(ctx context.Context, req *csi.Valida
 	}
 
 	log := d.log.WithFields(logrus.Fields{
-		"volume_id":              req.VolumeId,
-		"volume_capabilities":    req.VolumeCapabilities,
-		"supported_capabilities": supportedAccessMode,
-		"method":                 "validate_volume_capabilities",
+		"volume_id":           req.VolumeId,
+		"volume_capabilities": req.VolumeCapabilities,
+		"method":              "validate_volume_capabilities",
 	})
 	log.Info("validate volume capabilities called")
 
@@ -525,14 +691,18 @@ func (d *Driver) ValidateVolumeCapabilities(ctx context.Context, req *csi.Valida
 		return nil, err
 	}
 
+	if violations := validateCapabilities(req.VolumeCapabilities); len(violations) > 0 {
+		resp := &csi.ValidateVolumeCapabilitiesResponse{
+			Message: fmt.Sprintf("volume capabilities cannot be satisified: %s", strings.Join(violations, "; ")),
+		}
+		log.WithField("message", resp.Message).Info("unsupported capabilities")
+		return resp, nil
+	}
+
 	// if it's not supported (i.e: wrong region), we shouldn't override it
 	resp := &csi.ValidateVolumeCapabilitiesResponse{
 		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
-			VolumeCapabilities: []*csi.VolumeCapability{
-				{
-					AccessMode: supportedAccessMode,
-				},
-			},
+			VolumeCapabilities: req.VolumeCapabilities,
 		},
 	}
 
@@ -540,7 +710,9 @@ func (d *Driver) ValidateVolumeCapabilities(ctx context.Context, req *csi.Valida
 	return resp, nil
 }
 
-// ListVolumes returns a list of all requested volumes
+// ListVolumes returns a list of all requested volumes. If --do-tag is set,
+// only the volumes carrying the tag are listed unless --list-unowned-resources
+// is set as well.
 func (d *Driver) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
 	log := d.log.WithFields(logrus.Fields{
 		"max_entries":        req.MaxEntries,
@@ -558,24 +730,29 @@ func (d *Driver) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (
 		startingToken = int32(parsedToken)
 	}
 
-	untypedVolumes, nextToken, err := listResources(ctx, log, startingToken, req.MaxEntries, func(ctx context.Context, listOpts *godo.ListOptions) ([]interface{}, *godo.Response, error) {
-		volListOpts := &godo.ListVolumeParams{
-			ListOptions: listOpts,
-			Region:      d.region,
-		}
-		volumes, resp, err := d.storage.ListVolumes(ctx, volListOpts)
+	var (
+		untypedVolumes []interface{}
+		nextToken      int32
+		err            error
+	)
+	if regions := d.servedRegions(); len(regions) == 1 && !d.filtersOwnership() {
+		untypedVolumes, nextToken, err = listResources(ctx, log, startingToken, req.MaxEntries, d.volumeLister(regions[0]))
 		if err != nil {
-			return nil, resp, err
+			return nil, fmt.Errorf("ListVolumes failed to list resources: %w", err)
 		}
-
-		untypedVolumes := make([]interface{}, 0, len(volumes))
-		for _, volume := range volumes {
-			untypedVolumes = append(untypedVolumes, volume)
+	} else {
+		// API pages can neither span regions nor skip volumes not owned by
+		// the driver, so the owned volumes of all regions are collected and
+		// paged through in memory
+		for _, region := range regions {
+			regionVolumes, _, err := listResources(ctx, log.WithField("region", region), 0, 0, d.volumeLister(region))
+			if err != nil {
+				return nil, fmt.Errorf("ListVolumes failed to list resources in region %s: %w", region, err)
+			}
+			untypedVolumes = append(untypedVolumes, regionVolumes...)
 		}
-		return untypedVolumes, resp, err
-	})
-	if err != nil {
-		return nil, fmt.Errorf("ListVolumes failed to list resources: %w", err)
+		untypedVolumes = d.filterOwnedResources(untypedVolumes)
+		untypedVolumes, nextToken = pageResources(untypedVolumes, startingToken, req.MaxEntries)
 	}
 
 	volumes := make([]godo.Volume, 0, len(untypedVolumes))
@@ -583,13 +760,19 @@ func (d *Driver) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (
 		volumes = append(volumes, untypedVolume.(godo.Volume))
 	}
 
+	// the volume condition costs additional API calls per volume, so it is
+	// only reported by ControllerGetVolume
 	var entries []*csi.ListVolumesResponse_Entry
 	for _, vol := range volumes {
+		vol := vol
 		entries = append(entries, &csi.ListVolumesResponse_Entry{
 			Volume: &csi.Volume{
 				VolumeId:      vol.ID,
 				CapacityBytes: vol.SizeGigaBytes * giB,
 			},
+			Status: &csi.ListVolumesResponse_VolumeStatus{
+				PublishedNodeIds: publishedNodeIDs(&vol),
+			},
 		})
 	}
 
@@ -605,14 +788,94 @@ func (d *Driver) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (
 	return resp, nil
 }
 
-// GetCapacity returns the capacity of the storage pool
+// snapshotLister returns a godoLister listing the volume snapshots.
+func (d *Driver) snapshotLister() godoLister {
+	return func(ctx context.Context, listOpts *godo.ListOptions) ([]interface{}, *godo.Response, error) {
+		snapshots, resp, err := d.snapshots.ListVolume(ctx, listOpts)
+		if err != nil {
+			return nil, resp, err
+		}
+
+		untypedSnapshots := make([]interface{}, 0, len(snapshots))
+		for _, snap := range snapshots {
+			untypedSnapshots = append(untypedSnapshots, snap)
+		}
+		return untypedSnapshots, resp, err
+	}
+}
+
+// volumeLister returns a godoLister listing the volumes in the given region.
+func (d *Driver) volumeLister(region string) godoLister {
+	return func(ctx context.Context, listOpts *godo.ListOptions) ([]interface{}, *godo.Response, error) {
+		volListOpts := &godo.ListVolumeParams{
+			ListOptions: listOpts,
+			Region:      region,
+		}
+		volumes, resp, err := d.storage.ListVolumes(ctx, volListOpts)
+		if err != nil {
+			return nil, resp, err
+		}
+
+		untypedVolumes := make([]interface{}, 0, len(volumes))
+		for _, volume := range volumes {
+			untypedVolumes = append(untypedVolumes, volume)
+		}
+		return untypedVolumes, resp, err
+	}
+}
+
+// GetCapacity returns the capacity of the storage pool. DigitalOcean does not
+// have a notion of a storage pool, so the capacity is derived from the number
+// of volumes the account is still allowed to create, each of which can be at
+// most maximumVolumeSizeInBytes large.
 func (d *Driver) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
-	// TODO(arslan): check if we can provide this information somehow
-	d.log.WithFields(logrus.Fields{
-		"params": req.Parameters,
-		"method": "get_capacity",
-	}).Warn("get capacity is not implemented")
-	return nil, status.Error(codes.Unimplemented, "")
+	log := d.log.WithFields(logrus.Fields{
+		"params":              req.Parameters,
+		"volume_capabilities": req.VolumeCapabilities,
+		"accessible_topology": req.AccessibleTopology,
+		"method":              "get_capacity",
+	})
+	log.Info("get capacity called")
+
+	if violations := validateCapabilities(req.VolumeCapabilities); len(violations) > 0 {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("volume capabilities cannot be satisified: %s", strings.Join(violations, "; ")))
+	}
+
+	region := d.region
+	if r, ok := regionFromTopology(req.AccessibleTopology); ok {
+		if !d.servesRegion(r) {
+			// volumes can only be created in the regions the driver serves
+			log.WithField("region", r).Info("no capacity available in foreign region")
+			return &csi.GetCapacityResponse{AvailableCapacity: 0}, nil
+		}
+		region = r
+	}
+
+	details, err := d.getLimitDetails(ctx, region)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get volume limit details: %s", err)
+	}
+
+	var availableCapacity int64
+	switch {
+	case details.limit == 0:
+		// administrative accounts have no volume limit, so the only constraint
+		// left is the size of a single volume
+		availableCapacity = maximumVolumeSizeInBytes
+	case details.numVolumes < details.limit:
+		availableCapacity = int64(details.limit-details.numVolumes) * maximumVolumeSizeInBytes
+	}
+
+	resp := &csi.GetCapacityResponse{
+		AvailableCapacity: availableCapacity,
+	}
+
+	log.WithFields(logrus.Fields{
+		"limit":       details.limit,
+		"num_volumes": details.numVolumes,
+		"response":    resp,
+	}).Info("capacity calculated")
+	return resp, nil
 }
 
 // ControllerGetCapabilities returns the capabilities of the controller service.
@@ -627,15 +890,23 @@ func (d *Driver) ControllerGetCapabilities(ctx context.Context, req *csi.Control
 		}
 	}
 
-	var caps []*csi.ControllerServiceCapability
-	for _, cap := range []csi.ControllerServiceCapability_RPC_Type{
+	rpcTypes := []csi.ControllerServiceCapability_RPC_Type{
 		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
 		csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
 		csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+		csi.ControllerServiceCapability_RPC_GET_CAPACITY,
 		csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
 		csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
 		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
-	} {
+		csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES,
+		csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
+		csi.ControllerServiceCapability_RPC_GET_VOLUME,
+		csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+	}
+
+	var caps []*csi.ControllerServiceCapability
+	for _, cap := range rpcTypes {
 		caps = append(caps, newCap(cap))
 	}
 
@@ -670,52 +941,43 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 
 	log.Info("create snapshot is called")
 
-	// get snapshot first, if it's created do no thing
+	description, tags, err := renderMetadata(req.GetParameters(), snapshotMetadataKeys)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 
-	opts := &godo.ListOptions{
-		Page:    1,
-		PerPage: 50,
+	// get snapshot first, if it's created do no thing
+	existingSnap, err := d.findVolumeSnapshot(ctx, req.GetSourceVolumeId(), req.GetName())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
 	}
-	for {
-		snapshots, resp, err := d.storage.ListSnapshots(ctx, req.GetSourceVolumeId(), opts)
+	if existingSnap != nil {
+		ready, err := d.newSnapshotReadiness().ready(ctx, existingSnap)
 		if err != nil {
-			return nil, status.Errorf(codes.Internal, "failed to list snapshots on page %d: %s", opts.Page, err)
+			return nil, status.Error(codes.Internal, err.Error())
 		}
 
-		for _, snap := range snapshots {
-			if snap.Name == req.GetName() && snap.ResourceID == req.GetSourceVolumeId() {
-				s, err := toCSISnapshot(&snap)
-				if err != nil {
-					return nil, status.Errorf(codes.Internal,
-						"failed to convert DO snapshot %q to CSI snapshot: %s", snap.Name, err)
-				}
-
-				snapResp := &csi.CreateSnapshotResponse{
-					Snapshot: s,
-				}
-				log.WithField("response", snapResp).Info("existing snapshot found")
-				return snapResp, nil
-			}
+		s, err := toCSISnapshot(existingSnap, ready)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal,
+				"failed to convert DO snapshot %q to CSI snapshot: %s", existingSnap.Name, err)
 		}
 
-		if resp.Links == nil || resp.Links.IsLastPage() {
-			break
+		snapResp := &csi.CreateSnapshotResponse{
+			Snapshot: s,
 		}
-
-		page, err := resp.Links.CurrentPage()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get current page: %s", err)
-		}
-		opts.Page = page + 1
+		log.WithFields(logrus.Fields{
+			"response":     snapResp,
+			"ready_to_use": s.ReadyToUse,
+		}).Info("existing snapshot found")
+		return snapResp, nil
 	}
 
 	snapReq := &godo.SnapshotCreateRequest{
 		VolumeID:    req.GetSourceVolumeId(),
 		Name:        req.GetName(),
-		Description: createdByDO,
-	}
-	if d.doTag != "" {
-		snapReq.Tags = append(snapReq.Tags, d.doTag)
+		Description: description,
+		Tags:        appendTag(tags, d.doTag),
 	}
 
 	snap, resp, err := d.storage.CreateSnapshot(ctx, snapReq)
@@ -734,7 +996,12 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	s, err := toCSISnapshot(snap)
+	ready, err := d.newSnapshotReadiness().ready(ctx, snap)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	s, err := toCSISnapshot(snap, ready)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal,
 			"couldn't convert DO snapshot to CSI snapshot: %s", err.Error())
@@ -743,7 +1010,10 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 	snapResp := &csi.CreateSnapshotResponse{
 		Snapshot: s,
 	}
-	log.WithField("response", resp).Info("snapshot created")
+	log.WithFields(logrus.Fields{
+		"response":     resp,
+		"ready_to_use": s.ReadyToUse,
+	}).Info("snapshot created")
 	return snapResp, nil
 }
 
@@ -778,9 +1048,10 @@ func (d *Driver) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequ
 }
 
 // ListSnapshots returns the information about all snapshots on the storage
-// system within the given parameters regardless of how they were created.
-// ListSnapshots shold not list a snapshot that is being created but has not
-// been cut successfully yet.
+// system within the given parameters regardless of how they were created,
+// unless they are scoped to the snapshots owned by the driver.
+// Snapshots that are still being taken are listed with ReadyToUse set to
+// false.
 func (d *Driver) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
 	listResp := &csi.ListSnapshotsResponse{}
 	log := d.log.WithFields(logrus.Fields{
@@ -799,8 +1070,15 @@ func (d *Driver) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsReques
 			if resp == nil || resp.StatusCode != http.StatusNotFound {
 				return nil, status.Errorf(codes.Internal, "failed to get snapshot by ID %s: %s", req.SnapshotId, err)
 			}
+		} else if !d.ownsResource(snapshot.Tags) {
+			log.Info("snapshot is not owned by the driver")
 		} else {
-			snap, err := toCSISnapshot(snapshot)
+			ready, err := d.newSnapshotReadiness().ready(ctx, snapshot)
+			if err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+
+			snap, err := toCSISnapshot(snapshot, ready)
 			if err != nil {
 				return nil, status.Errorf(codes.Internal,
 					"failed to convert DO snapshot to CSI snapshot: %s", err)
@@ -823,21 +1101,27 @@ func (d *Driver) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsReques
 			startingToken = int32(parsedToken)
 		}
 
-		untypedSnapshots, nextToken, err := listResources(ctx, log, startingToken, req.MaxEntries, func(ctx context.Context, listOpts *godo.ListOptions) ([]interface{}, *godo.Response, error) {
-			snapshots, resp, err := d.snapshots.ListVolume(ctx, listOpts)
+		lister := d.snapshotLister()
 
+		var (
+			untypedSnapshots []interface{}
+			nextToken        int32
+			err              error
+		)
+		if !d.filtersOwnership() {
+			untypedSnapshots, nextToken, err = listResources(ctx, log, startingToken, req.MaxEntries, lister)
 			if err != nil {
-				return nil, resp, err
+				return nil, fmt.Errorf("ListSnapshots failed to list resources: %w", err)
 			}
-
-			untypedSnapshots := make([]interface{}, 0, len(snapshots))
-			for _, snap := range snapshots {
-				untypedSnapshots = append(untypedSnapshots, snap)
+		} else {
+			// API pages cannot skip snapshots not owned by the driver, so
+			// the owned snapshots are collected and paged through in memory
+			untypedSnapshots, _, err = listResources(ctx, log, 0, 0, lister)
+			if err != nil {
+				return nil, fmt.Errorf("ListSnapshots failed to list resources: %w", err)
 			}
-			return untypedSnapshots, resp, err
-		})
-		if err != nil {
-			return nil, fmt.Errorf("ListSnapshots failed to list resources: %w", err)
+			untypedSnapshots = d.filterOwnedResources(untypedSnapshots)
+			untypedSnapshots, nextToken = pageResources(untypedSnapshots, startingToken, req.MaxEntries)
 		}
 
 		snapshots := make([]godo.Snapshot, 0, len(untypedSnapshots))
@@ -845,9 +1129,15 @@ func (d *Driver) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsReques
 			snapshots = append(snapshots, untypedSnapshot.(godo.Snapshot))
 		}
 
+		readiness := d.newSnapshotReadiness()
 		entries := make([]*csi.ListSnapshotsResponse_Entry, 0, len(snapshots))
 		for _, snapshot := range snapshots {
-			snap, err := toCSISnapshot(&snapshot)
+			ready, err := readiness.ready(ctx, &snapshot)
+			if err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+
+			snap, err := toCSISnapshot(&snapshot, ready)
 			if err != nil {
 				return nil, status.Errorf(codes.Internal,
 					"failed to convert DO snapshot to CSI snapshot: %s", err)
@@ -906,7 +1196,7 @@ func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.Controller
 		return &csi.ControllerExpandVolumeResponse{CapacityBytes: volume.SizeGigaBytes * giB, NodeExpansionRequired: true}, nil
 	}
 
-	action, _, err := d.storageActions.Resize(ctx, req.GetVolumeId(), int(resizeGigaBytes), d.region)
+	action, _, err := d.storageActions.Resize(ctx, req.GetVolumeId(), int(resizeGigaBytes), d.volumeRegion(volume))
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "cannot resize volume %s: %s", req.GetVolumeId(), err.Error())
 	}
@@ -933,12 +1223,108 @@ func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.Controller
 	return &csi.ControllerExpandVolumeResponse{CapacityBytes: resizeGigaBytes * giB, NodeExpansionRequired: nodeExpansionRequired}, nil
 }
 
-// ControllerGetVolume gets a specific volume.
-// The call is used for the CSI health check feature
-// (https://github.com/kubernetes/enhancements/pull/1077) which we do not
-// support yet.
+// ControllerGetVolume gets a specific volume. The call is used for the CSI
+// health check feature (https://github.com/kubernetes/enhancements/pull/1077)
+// to report on the condition of the volume.
 func (d *Driver) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+	if req.VolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "ControllerGetVolume Volume ID must be provided")
+	}
+
+	log := d.log.WithFields(logrus.Fields{
+		"volume_id": req.VolumeId,
+		"method":    "controller_get_volume",
+	})
+	log.Info("controller get volume called")
+
+	vol, resp, err := d.storage.GetVolume(ctx, req.VolumeId)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, status.Errorf(codes.NotFound, "volume %q does not exist", req.VolumeId)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to get volume %q: %s", req.VolumeId, err)
+	}
+
+	condition, err := d.getVolumeCondition(ctx, vol)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to determine condition of volume %q: %s", req.VolumeId, err)
+	}
+
+	getResp := &csi.ControllerGetVolumeResponse{
+		Volume: d.toCSIVolume(vol),
+		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+			PublishedNodeIds: publishedNodeIDs(vol),
+			VolumeCondition:  condition,
+		},
+	}
+
+	log.WithField("response", getResp).Info("volume retrieved")
+	return getResp, nil
+}
+
+// getVolumeCondition inspects the droplets and pending actions of the given
+// volume and reports whether it is in an abnormal state.
+func (d *Driver) getVolumeCondition(ctx context.Context, vol *godo.Volume) (*csi.VolumeCondition, error) {
+	for _, dropletID := range vol.DropletIDs {
+		_, resp, err := d.droplets.Get(ctx, dropletID)
+		if err != nil {
+			if resp == nil || resp.StatusCode != http.StatusNotFound {
+				return nil, fmt.Errorf("failed to get droplet %d: %s", dropletID, err)
+			}
+			return &csi.VolumeCondition{
+				Abnormal: true,
+				Message:  fmt.Sprintf("volume is attached to droplet %d which does not exist anymore", dropletID),
+			}, nil
+		}
+	}
+
+	actions, _, err := d.storageActions.List(ctx, vol.ID, &godo.ListOptions{
+		Page:    1,
+		PerPage: 50,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list actions: %s", err)
+	}
+
+	for _, action := range actions {
+		if action.Status != godo.ActionInProgress || action.StartedAt == nil {
+			continue
+		}
+
+		if pending := time.Since(action.StartedAt.Time); pending > stuckActionThreshold {
+			return &csi.VolumeCondition{
+				Abnormal: true,
+				Message:  fmt.Sprintf("%s action %d has been pending for %s", action.Type, action.ID, pending.Round(time.Second)),
+			}, nil
+		}
+	}
+
+	return &csi.VolumeCondition{
+		Abnormal: false,
+		Message:  "volume is healthy",
+	}, nil
+}
+
+// toCSIVolume converts a DO Volume struct into a csi.Volume struct
+func (d *Driver) toCSIVolume(vol *godo.Volume) *csi.Volume {
+	return &csi.Volume{
+		VolumeId:      vol.ID,
+		CapacityBytes: vol.SizeGigaBytes * giB,
+		AccessibleTopology: []*csi.Topology{
+			{
+				Segments: d.topologySegments(d.volumeRegion(vol)),
+			},
+		},
+	}
+}
+
+// publishedNodeIDs returns the IDs of the nodes the volume is attached to.
+func publishedNodeIDs(vol *godo.Volume) []string {
+	nodeIDs := make([]string, 0, len(vol.DropletIDs))
+	for _, dropletID := range vol.DropletIDs {
+		nodeIDs = append(nodeIDs, strconv.Itoa(dropletID))
+	}
+	return nodeIDs
 }
 
 // extractStorage extracts the storage size in bytes from the given capacity
@@ -1020,6 +1406,166 @@ func formatBytes(inputBytes int64) string {
 	return result + unit
 }
 
+// lockDroplet queues the caller behind other volume actions for the given
+// droplet that were issued by this controller. The returned function must be
+// called once the caller's volume action has completed.
+func (d *Driver) lockDroplet(ctx context.Context, log *logrus.Entry, dropletID int) (func(), error) {
+	waited, err := d.dropletLocks.lock(ctx, dropletID)
+	if err != nil {
+		// sending an abort makes sure the csi-attacher retries with the next backoff tick
+		return nil, status.Errorf(codes.Aborted, "timed out waiting for pending volume actions on droplet %d: %s", dropletID, err)
+	}
+	if waited {
+		log.Info("pending volume actions on droplet completed")
+	}
+
+	return func() {
+		d.dropletLocks.unlock(dropletID)
+	}, nil
+}
+
+// resumeVolumeAction waits on the tracked action of the volume that is still
+// in progress, e.g., because the request that issued it timed out. It returns
+// whether the volume is attached to the given droplet afterwards. The API is
+// only consulted if an action is tracked for the volume; otherwise the given
+// volume is trusted.
+func (d *Driver) resumeVolumeAction(ctx context.Context, log *logrus.Entry, vol *godo.Volume, dropletID int) (bool, error) {
+	volumeID := vol.ID
+	action, tracked, err := d.findInProgressAction(ctx, log, volumeID)
+	if err != nil {
+		return false, status.Errorf(codes.Internal, "failed to find in-progress actions for volume %q: %s", volumeID, err)
+	}
+	if !tracked {
+		return attachedToDroplet(vol, dropletID), nil
+	}
+
+	if action != nil {
+		if action.Status == actionErrored {
+			return false, status.Errorf(codes.Internal, "%s action %d for volume %s errored", action.Type, action.ID, volumeID)
+		}
+
+		log.WithFields(logrus.Fields{
+			"action_id":   action.ID,
+			"action_type": action.Type,
+		}).Info("waiting for in-progress volume action")
+		if err := d.waitAction(ctx, log, volumeID, action.ID); err != nil {
+			return false, err
+		}
+	}
+
+	return d.volumeAttachedToDroplet(ctx, volumeID, dropletID)
+}
+
+// resumeListedAction waits on an in-progress action found among the most
+// recent actions of the volume. It is used when the API rejects an action
+// because of a pending event while no action is tracked for the volume, e.g.,
+// because the controller restarted without a state file. It returns whether
+// an action was resumed and whether the volume is attached to the given
+// droplet afterwards.
+func (d *Driver) resumeListedAction(ctx context.Context, log *logrus.Entry, volumeID string, dropletID int) (bool, bool, error) {
+	actions, _, err := d.storageActions.List(ctx, volumeID, &godo.ListOptions{
+		Page:    1,
+		PerPage: 50,
+	})
+	if err != nil {
+		return false, false, status.Errorf(codes.Internal, "failed to list actions for volume %q: %s", volumeID, err)
+	}
+
+	var action *godo.Action
+	for i := range actions {
+		if actions[i].Status == godo.ActionInProgress {
+			action = &actions[i]
+			break
+		}
+	}
+	if action == nil {
+		return false, false, nil
+	}
+
+	d.trackAction(log, volumeID, dropletID, action)
+	log.WithFields(logrus.Fields{
+		"action_id":   action.ID,
+		"action_type": action.Type,
+	}).Info("waiting for in-progress volume action")
+	if err := d.waitAction(ctx, log, volumeID, action.ID); err != nil {
+		return true, false, err
+	}
+
+	attached, err := d.volumeAttachedToDroplet(ctx, volumeID, dropletID)
+	return true, attached, err
+}
+
+// volumeAttachedToDroplet returns whether the volume is attached to the given
+// droplet according to the API. Actions might have targeted a different
+// droplet, so their outcome is checked this way instead of trusting their
+// type.
+func (d *Driver) volumeAttachedToDroplet(ctx context.Context, volumeID string, dropletID int) (bool, error) {
+	vol, resp, err := d.storage.GetVolume(ctx, volumeID)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return false, status.Errorf(codes.NotFound, "volume %q does not exist", volumeID)
+		}
+		return false, err
+	}
+	return attachedToDroplet(vol, dropletID), nil
+}
+
+// attachedToDroplet returns whether the given volume is attached to the given
+// droplet.
+func attachedToDroplet(vol *godo.Volume, dropletID int) bool {
+	for _, id := range vol.DropletIDs {
+		if id == dropletID {
+			return true
+		}
+	}
+	return false
+}
+
+// findInProgressAction returns the action tracked for the volume if it is
+// still in progress or has errored; actions that are no longer in progress
+// are untracked. The returned bool reports whether an action was tracked at
+// all; the actions of volumes without a tracked action are not looked up so
+// that publishing and unpublishing cost no extra API calls.
+func (d *Driver) findInProgressAction(ctx context.Context, log *logrus.Entry, volumeID string) (*godo.Action, bool, error) {
+	tracked, ok := d.actions.get(volumeID)
+	if !ok {
+		return nil, false, nil
+	}
+
+	action, resp, err := d.storageActions.Get(ctx, volumeID, tracked.ID)
+	switch {
+	case err == nil && action.Status == godo.ActionInProgress:
+		return action, true, nil
+	case err == nil && action.Status == actionErrored:
+		d.untrackAction(log, volumeID, tracked.ID)
+		return action, true, nil
+	case err == nil || (resp != nil && resp.StatusCode == http.StatusNotFound):
+		d.untrackAction(log, volumeID, tracked.ID)
+		return nil, true, nil
+	default:
+		return nil, true, fmt.Errorf("failed to get action %d: %s", tracked.ID, err)
+	}
+}
+
+// trackAction records the given action as outstanding for the volume. Since
+// tracking is merely an optimization, failures are only logged.
+func (d *Driver) trackAction(log *logrus.Entry, volumeID string, dropletID int, action *godo.Action) {
+	err := d.actions.track(volumeID, trackedAction{
+		ID:        action.ID,
+		Type:      action.Type,
+		DropletID: dropletID,
+	})
+	if err != nil {
+		log.WithError(err).Warn("failed to track volume action")
+	}
+}
+
+func (d *Driver) untrackAction(log *logrus.Entry, volumeID string, actionID int) {
+	if err := d.actions.untrack(volumeID, actionID); err != nil {
+		log.WithError(err).Warn("failed to untrack volume action")
+	}
+}
+
 // waitAction waits until the given action for the volume is completed
 func (d *Driver) waitAction(ctx context.Context, log *logrus.Entry, volumeID string, actionID int) error {
 	log = log.WithFields(logrus.Fields{
@@ -1032,27 +1578,32 @@ func (d *Driver) waitAction(ctx context.Context, log *logrus.Entry, volumeID str
 	ctx, cancel := context.WithTimeout(ctx, d.waitActionTimeout)
 	defer cancel()
 
-	err := wait.PollUntil(1*time.Second, wait.ConditionFunc(func() (done bool, err error) {
-		action, _, err := d.storageActions.Get(ctx, volumeID, actionID)
-		if err != nil {
-			ctxCanceled := ctx.Err() != nil
-			if !ctxCanceled {
-				log.WithError(err).Warn("getting action for volume")
-				return false, nil
-			}
-
-			return false, fmt.Errorf("failed to get action %d for volume %s: %s", actionID, volumeID, err)
-		}
-
-		log.WithField("action_status", action.Status).Info("action received")
-
-		if action.Status == godo.ActionCompleted {
+	start := time.Now()
+	results, stop := d.actionWatcher.watch(volumeID, actionID)
+	defer stop()
+
+	var err error
+	select {
+	case res := <-results:
+		switch {
+		case res.err != nil:
+			err = res.err
+		case res.action.Status == actionErrored:
+			d.untrackAction(log, volumeID, actionID)
+			err = status.Errorf(codes.Internal, "%s action %d for volume %s errored", res.action.Type, actionID, volumeID)
+		default:
 			log.Info("action completed")
-			return true, nil
+			d.untrackAction(log, volumeID, actionID)
 		}
+	case <-ctx.Done():
+		err = wait.ErrWaitTimeout
+	}
 
-		return false, nil
-	}), ctx.Done())
+	result := "completed"
+	if err != nil {
+		result = "failed"
+	}
+	d.metrics.observeWaitAction(result, time.Since(start))
 
 	return err
 }
@@ -1062,27 +1613,51 @@ type limitDetails struct {
 	numVolumes int
 }
 
-// checkLimit checks whether the user hit their account volume limit.
-func (d *Driver) checkLimit(ctx context.Context) (*limitDetails, error) {
+// checkLimit checks whether the user hit their account volume limit when
+// creating a volume in the given region.
+func (d *Driver) checkLimit(ctx context.Context, region string) (*limitDetails, error) {
 	// only one provisioner runs, we can make sure to prevent burst creation
 	d.readyMu.Lock()
 	defer d.readyMu.Unlock()
 
+	details, err := d.getLimitDetails(ctx, region)
+	if err != nil {
+		return nil, err
+	}
+
+	// administrative accounts might have zero length limits, make sure to not check them
+	if details.limit == 0 {
+		return nil, nil //  hail to the king!
+	}
+
+	if details.limit <= details.numVolumes {
+		return details, nil
+	}
+
+	return nil, nil
+}
+
+// getLimitDetails returns the account volume limit along with the number of
+// volumes currently in use in the given region. A zero limit denotes an
+// account without a volume limit, in which case the number of volumes is not
+// looked up: checkLimit does not enforce it and GetCapacity reports the size
+// of a single volume for it.
+func (d *Driver) getLimitDetails(ctx context.Context, region string) (*limitDetails, error) {
 	account, _, err := d.account.Get(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get account information: %s", err)
 	}
 
-	// administrative accounts might have zero length limits, make sure to not check them
 	if account.VolumeLimit == 0 {
-		return nil, nil //  hail to the king!
+		d.metrics.setVolumeLimit(0, 0)
+		return &limitDetails{}, nil
 	}
 
 	// The API returns the limit for *all* regions, so passing the region
 	// down as a parameter doesn't change the response. Nevertheless, this
 	// is something we should be aware of.
 	_, resp, err := d.storage.ListVolumes(ctx, &godo.ListVolumeParams{
-		Region: d.region,
+		Region: region,
 		ListOptions: &godo.ListOptions{
 			Page:    1,
 			PerPage: 1,
@@ -1096,19 +1671,115 @@ func (d *Driver) checkLimit(ctx context.Context) (*limitDetails, error) {
 		// This should really never happen.
 		return nil, errors.New("no meta field available in list volumes response")
 	}
-	numVolumes := resp.Meta.Total
-	if account.VolumeLimit <= numVolumes {
-		return &limitDetails{
-			limit:      account.VolumeLimit,
-			numVolumes: numVolumes,
-		}, nil
+
+	d.metrics.setVolumeLimit(account.VolumeLimit, resp.Meta.Total)
+
+	return &limitDetails{
+		limit:      account.VolumeLimit,
+		numVolumes: resp.Meta.Total,
+	}, nil
+}
+
+// findVolumeSnapshot returns the snapshot with the given name taken from the
+// given volume. It returns nil if no such snapshot exists.
+func (d *Driver) findVolumeSnapshot(ctx context.Context, volumeID, name string) (*godo.Snapshot, error) {
+	opts := &godo.ListOptions{
+		Page:    1,
+		PerPage: 50,
 	}
+	for {
+		snapshots, resp, err := d.storage.ListSnapshots(ctx, volumeID, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list snapshots on page %d: %s", opts.Page, err)
+		}
 
-	return nil, nil
+		for _, snap := range snapshots {
+			if snap.Name == name && snap.ResourceID == volumeID {
+				snap := snap
+				return &snap, nil
+			}
+		}
+
+		if resp.Links == nil || resp.Links.IsLastPage() {
+			return nil, nil
+		}
+
+		page, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get current page: %s", err)
+		}
+		opts.Page = page + 1
+	}
+}
+
+// cloneSnapshotName returns the name of the transient snapshot used to clone
+// a source volume into the volume with the given name. The name is derived
+// from the target volume so that retried CreateVolume calls find the snapshot
+// again.
+func cloneSnapshotName(volumeName string) string {
+	return cloneSnapshotPrefix + volumeName
+}
+
+// createCloneSnapshot takes the transient snapshot of the source volume that
+// the cloned volume is created from. An already existing snapshot is reused.
+func (d *Driver) createCloneSnapshot(ctx context.Context, log *logrus.Entry, sourceVolumeID, volumeName string) (*godo.Snapshot, error) {
+	name := cloneSnapshotName(volumeName)
+	log = log.WithField("clone_snapshot_name", name)
+
+	snap, err := d.findVolumeSnapshot(ctx, sourceVolumeID, name)
+	if err != nil {
+		return nil, err
+	}
+	if snap != nil {
+		log.WithField("clone_snapshot_id", snap.ID).Info("reusing existing clone snapshot")
+		return snap, nil
+	}
+
+	snapReq := &godo.SnapshotCreateRequest{
+		VolumeID:    sourceVolumeID,
+		Name:        name,
+		Description: createdByDO,
+	}
+	if d.doTag != "" {
+		snapReq.Tags = append(snapReq.Tags, d.doTag)
+	}
+
+	log.Info("creating clone snapshot")
+	snap, _, err = d.storage.CreateSnapshot(ctx, snapReq)
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithField("clone_snapshot_id", snap.ID).Info("clone snapshot created")
+	return snap, nil
+}
+
+// deleteCloneSnapshot removes the transient snapshot taken for cloning the
+// source volume into the volume with the given name, if it exists.
+func (d *Driver) deleteCloneSnapshot(ctx context.Context, log *logrus.Entry, sourceVolumeID, volumeName string) error {
+	snap, err := d.findVolumeSnapshot(ctx, sourceVolumeID, cloneSnapshotName(volumeName))
+	if err != nil {
+		return err
+	}
+	if snap == nil {
+		return nil
+	}
+
+	log = log.WithField("clone_snapshot_id", snap.ID)
+	resp, err := d.storage.DeleteSnapshot(ctx, snap.ID)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return err
+	}
+
+	log.Info("clone snapshot deleted")
+	return nil
 }
 
 // toCSISnapshot converts a DO Snapshot struct into a csi.Snapshot struct
-func toCSISnapshot(snap *godo.Snapshot) (*csi.Snapshot, error) {
+func toCSISnapshot(snap *godo.Snapshot, ready bool) (*csi.Snapshot, error) {
 	createdAt, err := time.Parse(time.RFC3339, snap.Created)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't parse snapshot's created field: %s", err.Error())
@@ -1123,18 +1794,18 @@ func toCSISnapshot(snap *godo.Snapshot) (*csi.Snapshot, error) {
 	return &csi.Snapshot{
 		SnapshotId:     snap.ID,
 		SourceVolumeId: snap.ResourceID,
-		SizeBytes:      int64(snap.MinDiskSize) * giB,
+		SizeBytes:      int64(math.Ceil(snap.SizeGigaBytes * giB)),
 		CreationTime:   tstamp,
-		ReadyToUse:     true,
+		ReadyToUse:     ready,
 	}, nil
 }
 
-// validateCapabilities validates the requested capabilities. It returns a list
-// of violations which may be empty if no violatons were found.
+// validateCapabilities validates the requested capabilities. It returns a
+// list of violations which may be empty if no violatons were found.
 func validateCapabilities(caps []*csi.VolumeCapability) []string {
 	violations := sets.NewString()
 	for _, cap := range caps {
-		if cap.GetAccessMode().GetMode() != supportedAccessMode.GetMode() {
+		if !supportedAccessModes[cap.GetAccessMode().GetMode()] {
 			violations.Insert(fmt.Sprintf("unsupported access mode %s", cap.GetAccessMode().GetMode().String()))
 		}
 
@@ -1150,6 +1821,28 @@ func validateCapabilities(caps []*csi.VolumeCapability) []string {
 	return violations.List()
 }
 
+// validateMkfsOptions validates the given mkfs options against the filesystem
+// of each requested mount capability.
+func validateMkfsOptions(caps []*csi.VolumeCapability, options string) error {
+	for _, cap := range caps {
+		mnt := cap.GetMount()
+		if mnt == nil {
+			continue
+		}
+
+		fsType := defaultFsType
+		if mnt.FsType != "" {
+			fsType = mnt.FsType
+		}
+
+		if _, err := parseMkfsOptions(fsType, options); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (d *Driver) tagVolume(parentCtx context.Context, vol *godo.Volume) error {
 	for _, tag := range vol.Tags {
 		if tag == d.doTag {
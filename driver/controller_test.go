@@ -275,6 +275,173 @@ func TestControllerExpandVolume(t *testing.T) {
 	}
 }
 
+func TestControllerGetVolume(t *testing.T) {
+	tests := []struct {
+		name          string
+		volume        *godo.Volume
+		actions       []godo.Action
+		wantCode      codes.Code
+		wantNodeIDs   []string
+		wantAbnormal  bool
+		wantMsgSubstr string
+	}{
+		{
+			name:     "volume does not exist",
+			wantCode: codes.NotFound,
+		},
+		{
+			name: "healthy detached volume",
+			volume: &godo.Volume{
+				ID:            "volume-id",
+				SizeGigaBytes: 10,
+			},
+			wantNodeIDs:   []string{},
+			wantMsgSubstr: "healthy",
+		},
+		{
+			name: "healthy attached volume",
+			volume: &godo.Volume{
+				ID:            "volume-id",
+				SizeGigaBytes: 10,
+				DropletIDs:    []int{1},
+			},
+			actions: []godo.Action{
+				{
+					ID:        1,
+					Status:    godo.ActionInProgress,
+					Type:      "attach_volume",
+					StartedAt: &godo.Timestamp{Time: time.Now()},
+				},
+			},
+			wantNodeIDs:   []string{"1"},
+			wantMsgSubstr: "healthy",
+		},
+		{
+			name: "droplet is gone",
+			volume: &godo.Volume{
+				ID:            "volume-id",
+				SizeGigaBytes: 10,
+				DropletIDs:    []int{42},
+			},
+			wantNodeIDs:   []string{"42"},
+			wantAbnormal:  true,
+			wantMsgSubstr: "droplet 42 which does not exist",
+		},
+		{
+			name: "action is stuck",
+			volume: &godo.Volume{
+				ID:            "volume-id",
+				SizeGigaBytes: 10,
+				DropletIDs:    []int{1},
+			},
+			actions: []godo.Action{
+				{
+					ID:        7,
+					Status:    godo.ActionInProgress,
+					Type:      "attach_volume",
+					StartedAt: &godo.Timestamp{Time: time.Now().Add(-2 * stuckActionThreshold)},
+				},
+			},
+			wantNodeIDs:   []string{"1"},
+			wantAbnormal:  true,
+			wantMsgSubstr: "attach_volume action 7 has been pending",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			volumes := map[string]*godo.Volume{}
+			if test.volume != nil {
+				volumes[test.volume.ID] = test.volume
+			}
+
+			d := &Driver{
+				region: "nyc3",
+				storage: &fakeStorageDriver{
+					volumes: volumes,
+				},
+				storageActions: &fakeStorageActionsDriver{
+					volumes: volumes,
+					actions: map[string][]godo.Action{
+						"volume-id": test.actions,
+					},
+				},
+				droplets: &fakeDropletsDriver{
+					droplets: map[int]*godo.Droplet{
+						1: {ID: 1},
+					},
+				},
+				log: logrus.New().WithField("test_enabed", true),
+			}
+
+			resp, err := d.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{
+				VolumeId: "volume-id",
+			})
+			if test.wantCode != codes.OK {
+				if status.Code(err) != test.wantCode {
+					t.Fatalf("got error %v, want code %s", err, test.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error: %s", err)
+			}
+
+			if resp.Volume.CapacityBytes != test.volume.SizeGigaBytes*giB {
+				t.Errorf("got capacity %d, want %d", resp.Volume.CapacityBytes, test.volume.SizeGigaBytes*giB)
+			}
+
+			if diff := cmp.Diff(resp.Status.PublishedNodeIds, test.wantNodeIDs); diff != "" {
+				t.Errorf("published node IDs mismatch (-got +want):\n%s", diff)
+			}
+
+			condition := resp.Status.VolumeCondition
+			if condition.Abnormal != test.wantAbnormal {
+				t.Errorf("got abnormal %t, want %t", condition.Abnormal, test.wantAbnormal)
+			}
+			if !strings.Contains(condition.Message, test.wantMsgSubstr) {
+				t.Errorf("want condition message %q to include %q", condition.Message, test.wantMsgSubstr)
+			}
+		})
+	}
+}
+
+func TestListVolumesOmitsVolumeCondition(t *testing.T) {
+	volumes := map[string]*godo.Volume{
+		"volume-id": {
+			ID:            "volume-id",
+			SizeGigaBytes: 10,
+			DropletIDs:    []int{1},
+		},
+	}
+
+	// neither droplets nor actions are configured so that looking them up
+	// per volume panics
+	d := &Driver{
+		region: "nyc3",
+		storage: &fakeStorageDriver{
+			volumes: volumes,
+		},
+		log: logrus.New().WithField("test_enabed", true),
+	}
+
+	resp, err := d.ListVolumes(context.Background(), &csi.ListVolumesRequest{})
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+
+	if len(resp.Entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(resp.Entries))
+	}
+	entry := resp.Entries[0]
+	if diff := cmp.Diff(entry.Status.PublishedNodeIds, []string{"1"}); diff != "" {
+		t.Errorf("published node IDs mismatch (-got +want):\n%s", diff)
+	}
+	if entry.Status.VolumeCondition != nil {
+		t.Errorf("got volume condition %v, want none", entry.Status.VolumeCondition)
+	}
+}
+
 func TestCreateVolume(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -298,6 +465,9 @@ func TestCreateVolume(t *testing.T) {
 					listVolumesErr: test.listVolumesErr,
 				},
 				snapshots: &fakeSnapshotsDriver{
+					snapshots: map[string]*godo.Snapshot{
+						"snapshotId": createGodoSnapshot("snapshotId", "snapshot", "volumeId"),
+					},
 					getSnapshotErr: test.getSnapshotErr,
 				},
 				log: logrus.New().WithField("test_enabed", true),
@@ -342,6 +512,207 @@ func TestCreateVolume(t *testing.T) {
 	}
 }
 
+func TestCreateVolumeClone(t *testing.T) {
+	tests := []struct {
+		name             string
+		sourceVolumeID   string
+		existingVolume   bool
+		existingSnapshot bool
+		snapshotPending  bool
+		wantCode         codes.Code
+	}{
+		{
+			name:           "source volume does not exist",
+			sourceVolumeID: "non-existent-id",
+			wantCode:       codes.NotFound,
+		},
+		{
+			name:           "clone created",
+			sourceVolumeID: "source-volume-id",
+		},
+		{
+			name:             "clone snapshot left over from previous attempt",
+			sourceVolumeID:   "source-volume-id",
+			existingSnapshot: true,
+		},
+		{
+			name:             "clone volume created by previous attempt",
+			sourceVolumeID:   "source-volume-id",
+			existingVolume:   true,
+			existingSnapshot: true,
+		},
+		{
+			name:             "clone snapshot in progress",
+			sourceVolumeID:   "source-volume-id",
+			existingSnapshot: true,
+			snapshotPending:  true,
+			wantCode:         codes.Unavailable,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			volumes := map[string]*godo.Volume{
+				"source-volume-id": {
+					ID:            "source-volume-id",
+					Name:          "source",
+					SizeGigaBytes: defaultVolumeSizeInBytes / giB,
+				},
+			}
+			if test.existingVolume {
+				volumes["clone-volume-id"] = &godo.Volume{
+					ID:            "clone-volume-id",
+					Name:          "clone",
+					SizeGigaBytes: defaultVolumeSizeInBytes / giB,
+				}
+			}
+			snapshots := map[string]*godo.Snapshot{}
+			if test.existingSnapshot {
+				snapshots["clone-snapshot-id"] = createGodoSnapshot("clone-snapshot-id", cloneSnapshotName("clone"), "source-volume-id")
+			}
+			actions := map[string][]godo.Action{}
+			if test.snapshotPending {
+				actions["source-volume-id"] = []godo.Action{
+					{
+						Type:      "snapshot",
+						Status:    godo.ActionInProgress,
+						StartedAt: &godo.Timestamp{Time: time.Now().Add(-time.Minute)},
+					},
+				}
+			}
+
+			d := &Driver{
+				region: "nyc3",
+				storage: &fakeStorageDriver{
+					volumes:   volumes,
+					snapshots: snapshots,
+				},
+				storageActions: &fakeStorageActionsDriver{
+					volumes: volumes,
+					actions: actions,
+				},
+				account: &fakeAccountDriver{},
+				log:     logrus.New().WithField("test_enabed", true),
+			}
+
+			resp, err := d.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name: "clone",
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+				VolumeContentSource: &csi.VolumeContentSource{
+					Type: &csi.VolumeContentSource_Volume{
+						Volume: &csi.VolumeContentSource_VolumeSource{
+							VolumeId: test.sourceVolumeID,
+						},
+					},
+				},
+			})
+			if test.wantCode != codes.OK {
+				if status.Code(err) != test.wantCode {
+					t.Fatalf("got error %v, want code %s", err, test.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error: %s", err)
+			}
+
+			if got := resp.Volume.GetContentSource().GetVolume().GetVolumeId(); got != test.sourceVolumeID {
+				t.Errorf("got content source volume ID %q, want %q", got, test.sourceVolumeID)
+			}
+
+			if len(snapshots) != 0 {
+				t.Errorf("got %d leftover snapshot(s), want none", len(snapshots))
+			}
+
+			var numClones int
+			for _, vol := range volumes {
+				if vol.Name == "clone" {
+					numClones++
+				}
+			}
+			if numClones != 1 {
+				t.Errorf("got %d cloned volume(s), want 1", numClones)
+			}
+		})
+	}
+}
+
+func TestValidateCapabilities(t *testing.T) {
+	capability := func(mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: mode,
+			},
+		}
+	}
+
+	tests := []struct {
+		name           string
+		caps           []*csi.VolumeCapability
+		wantViolations []string
+	}{
+		{
+			name: "single node writer",
+			caps: []*csi.VolumeCapability{capability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)},
+		},
+		{
+			name: "single node reader only",
+			caps: []*csi.VolumeCapability{capability(csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY)},
+		},
+		{
+			name: "single node single writer",
+			caps: []*csi.VolumeCapability{capability(csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER)},
+		},
+		{
+			name: "single node multi writer",
+			caps: []*csi.VolumeCapability{capability(csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER)},
+		},
+		{
+			name: "multi node reader only",
+			caps: []*csi.VolumeCapability{
+				capability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+				capability(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY),
+			},
+			wantViolations: []string{"unsupported access mode MULTI_NODE_READER_ONLY"},
+		},
+		{
+			name: "unknown access type",
+			caps: []*csi.VolumeCapability{
+				{
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+					},
+				},
+			},
+			wantViolations: []string{"unsupported access type"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			violations := validateCapabilities(test.caps)
+			if len(violations) == 0 && len(test.wantViolations) == 0 {
+				return
+			}
+			if diff := cmp.Diff(test.wantViolations, violations); diff != "" {
+				t.Errorf("violations mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
 func TestCheckLimit(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -389,7 +760,7 @@ func TestCheckLimit(t *testing.T) {
 				storage: storage,
 			}
 
-			gotDetails, err := d.checkLimit(context.Background())
+			gotDetails, err := d.checkLimit(context.Background(), "nyc3")
 			if err != nil {
 				t.Fatalf("got error: %s", err)
 			}
@@ -401,6 +772,100 @@ func TestCheckLimit(t *testing.T) {
 	}
 }
 
+func TestGetCapacity(t *testing.T) {
+	tests := []struct {
+		name         string
+		limit        int
+		numVolumes   int
+		topology     *csi.Topology
+		wantCapacity int64
+	}{
+		{
+			name:         "volumes remaining",
+			limit:        10,
+			numVolumes:   8,
+			wantCapacity: 2 * maximumVolumeSizeInBytes,
+		},
+		{
+			name:         "limit reached",
+			limit:        10,
+			numVolumes:   10,
+			wantCapacity: 0,
+		},
+		{
+			name:         "limit exceeded",
+			limit:        10,
+			numVolumes:   12,
+			wantCapacity: 0,
+		},
+		{
+			name:         "account without volume limit",
+			limit:        0,
+			numVolumes:   1000,
+			wantCapacity: maximumVolumeSizeInBytes,
+		},
+		{
+			name:       "matching region",
+			limit:      10,
+			numVolumes: 9,
+			topology: &csi.Topology{
+				Segments: map[string]string{"region": "nyc3"},
+			},
+			wantCapacity: maximumVolumeSizeInBytes,
+		},
+		{
+			name:       "additional region",
+			limit:      10,
+			numVolumes: 9,
+			topology: &csi.Topology{
+				Segments: map[string]string{"region": "ams3"},
+			},
+			wantCapacity: maximumVolumeSizeInBytes,
+		},
+		{
+			name:       "foreign region",
+			limit:      10,
+			numVolumes: 0,
+			topology: &csi.Topology{
+				Segments: map[string]string{"region": "fra1"},
+			},
+			wantCapacity: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage := &fakeStorageDriver{
+				volumes: map[string]*godo.Volume{},
+			}
+			for i := 0; i < test.numVolumes; i++ {
+				storage.volumes[strconv.Itoa(i)] = &godo.Volume{}
+			}
+
+			d := Driver{
+				region:  "nyc3",
+				regions: []string{"nyc3", "ams3"},
+				account: &fakeAccountDriver{
+					volumeLimit: test.limit,
+				},
+				storage: storage,
+				log:     logrus.New().WithField("test_enabed", true),
+			}
+
+			resp, err := d.GetCapacity(context.Background(), &csi.GetCapacityRequest{
+				AccessibleTopology: test.topology,
+			})
+			if err != nil {
+				t.Fatalf("got error: %s", err)
+			}
+
+			if resp.AvailableCapacity != test.wantCapacity {
+				t.Errorf("got capacity %d, want %d", resp.AvailableCapacity, test.wantCapacity)
+			}
+		})
+	}
+}
+
 type fakeStorageAction struct {
 	*fakeStorageActionsDriver
 	storageGetValsFunc func(invocation int) (*godo.Action, *godo.Response, error)
@@ -447,7 +912,7 @@ func TestWaitAction(t *testing.T) {
 					}, &godo.Response{}, nil
 				}
 			},
-			timeout: 5 * time.Second, // We need three 1-second ticks for the fake storage action to complete.
+			timeout: 5 * time.Second, // We need three polls, 1, 1 and 1.5 seconds apart, for the fake storage action to complete.
 			wantErr: nil,
 		},
 	}
@@ -456,13 +921,16 @@ func TestWaitAction(t *testing.T) {
 		test := test
 		t.Run(test.name, func(t *testing.T) {
 			t.Parallel()
+			storageActions := &fakeStorageAction{
+				fakeStorageActionsDriver: &fakeStorageActionsDriver{},
+				storageGetValsFunc:       test.storageGetValsFunc,
+			}
+			log := logrus.New().WithField("test_enabed", true)
 			d := Driver{
 				waitActionTimeout: test.timeout,
-				storageActions: &fakeStorageAction{
-					fakeStorageActionsDriver: &fakeStorageActionsDriver{},
-					storageGetValsFunc:       test.storageGetValsFunc,
-				},
-				log: logrus.New().WithField("test_enabed", true),
+				storageActions:    storageActions,
+				actionWatcher:     newActionWatcher(storageActions, log),
+				log:               log,
 			}
 
 			err := d.waitAction(
@@ -621,3 +1089,280 @@ func TestListSnapshot(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateSnapshotReadyToUse(t *testing.T) {
+	created := time.Now().UTC().Truncate(time.Second)
+
+	tests := []struct {
+		name          string
+		sizeGigaBytes float64
+		actions       []godo.Action
+		wantReady     bool
+		wantSizeBytes int64
+	}{
+		{
+			name: "snapshot in progress",
+			actions: []godo.Action{
+				{
+					Type:      "snapshot",
+					Status:    godo.ActionInProgress,
+					StartedAt: &godo.Timestamp{Time: created.Add(-time.Second)},
+				},
+			},
+		},
+		{
+			name: "snapshot started within the same second",
+			actions: []godo.Action{
+				{
+					Type:      "snapshot",
+					Status:    godo.ActionInProgress,
+					StartedAt: &godo.Timestamp{Time: created.Add(500 * time.Millisecond)},
+				},
+			},
+		},
+		{
+			name: "later snapshot in progress",
+			actions: []godo.Action{
+				{
+					Type:      "snapshot",
+					Status:    godo.ActionInProgress,
+					StartedAt: &godo.Timestamp{Time: created.Add(time.Minute)},
+				},
+			},
+			wantReady: true,
+		},
+		{
+			name: "other action in progress",
+			actions: []godo.Action{
+				{
+					Type:      "resize",
+					Status:    godo.ActionInProgress,
+					StartedAt: &godo.Timestamp{Time: created.Add(-time.Second)},
+				},
+			},
+			wantReady: true,
+		},
+		{
+			name: "empty snapshot completed",
+			actions: []godo.Action{
+				{
+					Type:      "snapshot",
+					Status:    godo.ActionCompleted,
+					StartedAt: &godo.Timestamp{Time: created.Add(-time.Second)},
+				},
+			},
+			wantReady: true,
+		},
+		{
+			name:          "snapshot completed",
+			sizeGigaBytes: 1.5,
+			wantReady:     true,
+			wantSizeBytes: 3 * giB / 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			snap := createGodoSnapshot("snapshot-id", "snapshot", "volume-id")
+			snap.SizeGigaBytes = test.sizeGigaBytes
+			snap.MinDiskSize = 10
+			snap.Created = created.Format(time.RFC3339)
+
+			d := &Driver{
+				storage: &fakeStorageDriver{
+					snapshots: map[string]*godo.Snapshot{snap.ID: snap},
+				},
+				storageActions: &fakeStorageActionsDriver{
+					actions: map[string][]godo.Action{"volume-id": test.actions},
+				},
+				log: logrus.New().WithField("test_enabed", true),
+			}
+
+			resp, err := d.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{
+				Name:           "snapshot",
+				SourceVolumeId: "volume-id",
+			})
+			if err != nil {
+				t.Fatalf("got error: %s", err)
+			}
+
+			if resp.Snapshot.ReadyToUse != test.wantReady {
+				t.Errorf("got ready to use %t, want %t", resp.Snapshot.ReadyToUse, test.wantReady)
+			}
+			if resp.Snapshot.SizeBytes != test.wantSizeBytes {
+				t.Errorf("got size %d, want %d", resp.Snapshot.SizeBytes, test.wantSizeBytes)
+			}
+		})
+	}
+}
+
+// resumingStorageActionsDriver reports the configured status for all actions,
+// or the initial status on the first lookup if set, and records the attach and
+// list calls. Attaching fails because of a pending event if pendingEvent is
+// set.
+type resumingStorageActionsDriver struct {
+	*fakeStorageActionsDriver
+	initialStatus string
+	status        string
+	pendingEvent  bool
+	onCompleted   func()
+	getCalls      int
+	attachCalls   int
+	listCalls     int
+}
+
+func (f *resumingStorageActionsDriver) List(ctx context.Context, volumeID string, opt *godo.ListOptions) ([]godo.Action, *godo.Response, error) {
+	f.listCalls++
+	return f.fakeStorageActionsDriver.List(ctx, volumeID, opt)
+}
+
+func (f *resumingStorageActionsDriver) Get(ctx context.Context, volumeID string, actionID int) (*godo.Action, *godo.Response, error) {
+	f.getCalls++
+	if f.getCalls == 1 && f.initialStatus != "" {
+		return &godo.Action{ID: actionID, Status: f.initialStatus, Type: "attach_volume"}, godoResponse(), nil
+	}
+	if f.status == godo.ActionCompleted && f.onCompleted != nil {
+		f.onCompleted()
+	}
+	return &godo.Action{ID: actionID, Status: f.status, Type: "attach_volume"}, godoResponse(), nil
+}
+
+func (f *resumingStorageActionsDriver) Attach(ctx context.Context, volumeID string, dropletID int) (*godo.Action, *godo.Response, error) {
+	f.attachCalls++
+	if f.pendingEvent {
+		resp := &godo.Response{Response: &http.Response{StatusCode: http.StatusUnprocessableEntity}}
+		return nil, resp, errors.New("422 Droplet already has a pending event.")
+	}
+	return nil, godoResponse(), nil
+}
+
+func TestControllerPublishVolumeResumesAction(t *testing.T) {
+	tests := []struct {
+		name            string
+		actions         []godo.Action
+		tracked         *trackedAction
+		initialStatus   string
+		status          string
+		pendingEvent    bool
+		wantAttachCalls int
+		wantListCalls   int
+		wantCode        codes.Code
+	}{
+		{
+			name:            "no in-progress action",
+			wantAttachCalls: 1,
+		},
+		{
+			name: "untracked in-progress attach is not looked up",
+			actions: []godo.Action{
+				{ID: 2, Status: godo.ActionInProgress, Type: "attach_volume"},
+			},
+			wantAttachCalls: 1,
+		},
+		{
+			name:    "tracked in-progress attach completes",
+			tracked: &trackedAction{ID: 2, Type: "attach_volume", DropletID: 1},
+			status:  godo.ActionCompleted,
+		},
+		{
+			name:          "tracked in-progress attach errors",
+			tracked:       &trackedAction{ID: 2, Type: "attach_volume", DropletID: 1},
+			initialStatus: godo.ActionInProgress,
+			status:        actionErrored,
+			wantCode:      codes.Internal,
+		},
+		{
+			name:     "tracked errored attach",
+			tracked:  &trackedAction{ID: 2, Type: "attach_volume", DropletID: 1},
+			status:   actionErrored,
+			wantCode: codes.Internal,
+		},
+		{
+			name: "untracked in-progress attach is resumed after pending event",
+			actions: []godo.Action{
+				{ID: 2, Status: godo.ActionInProgress, Type: "attach_volume"},
+			},
+			status:          godo.ActionCompleted,
+			pendingEvent:    true,
+			wantAttachCalls: 1,
+			wantListCalls:   1,
+		},
+		{
+			name: "pending event without in-progress attach",
+			actions: []godo.Action{
+				{ID: 2, Status: godo.ActionCompleted, Type: "attach_volume"},
+			},
+			pendingEvent:    true,
+			wantAttachCalls: 1,
+			wantListCalls:   1,
+			wantCode:        codes.Aborted,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vol := &godo.Volume{ID: "volume-id", Name: "volume"}
+			volumes := map[string]*godo.Volume{vol.ID: vol}
+
+			storageActions := &resumingStorageActionsDriver{
+				fakeStorageActionsDriver: &fakeStorageActionsDriver{
+					volumes: volumes,
+					actions: map[string][]godo.Action{
+						vol.ID: test.actions,
+					},
+				},
+				initialStatus: test.initialStatus,
+				status:        test.status,
+				pendingEvent:  test.pendingEvent,
+				onCompleted: func() {
+					vol.DropletIDs = []int{1}
+				},
+			}
+
+			tracker := &actionTracker{actions: map[string]trackedAction{}}
+			if test.tracked != nil {
+				tracker.actions[vol.ID] = *test.tracked
+			}
+
+			d := &Driver{
+				waitActionTimeout: defaultWaitActionTimeout,
+				storage: &fakeStorageDriver{
+					volumes: volumes,
+				},
+				storageActions: storageActions,
+				droplets: &fakeDropletsDriver{
+					droplets: map[int]*godo.Droplet{
+						1: {ID: 1},
+					},
+				},
+				dropletLocks:  newDropletLocks(),
+				actions:       tracker,
+				actionWatcher: newActionWatcher(storageActions, logrus.New().WithField("test_enabed", true)),
+				log:           logrus.New().WithField("test_enabed", true),
+			}
+
+			_, err := d.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+				VolumeId: vol.ID,
+				NodeId:   "1",
+				VolumeCapability: &csi.VolumeCapability{
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+					},
+				},
+			})
+			if status.Code(err) != test.wantCode {
+				t.Fatalf("got error %v, want code %s", err, test.wantCode)
+			}
+
+			if storageActions.attachCalls != test.wantAttachCalls {
+				t.Errorf("got %d attach calls, want %d", storageActions.attachCalls, test.wantAttachCalls)
+			}
+			if storageActions.listCalls != test.wantListCalls {
+				t.Errorf("got %d list calls, want %d", storageActions.listCalls, test.wantListCalls)
+			}
+			if _, ok := tracker.get(vol.ID); ok {
+				t.Error("got tracked action after it finished, want none")
+			}
+		})
+	}
+}
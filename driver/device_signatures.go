@@ -0,0 +1,89 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// AllowForeignSignaturesAttribute allows staging volumes whose device
+	// carries a partition table or the signature of a volume manager (see
+	// foreignSignatureTypes). It can be set in the StorageClass parameters or
+	// in the volume attributes of a pre-provisioned PersistentVolume.
+	AllowForeignSignaturesAttribute = DefaultDriverName + "/allow-foreign-signatures"
+
+	// blkidExitStatusAmbivalent defines the exit code returned from blkid
+	// when the low-level probing found conflicting signatures.
+	blkidExitStatusAmbivalent = 8
+)
+
+// foreignSignatureTypes maps the signature types reported by blkid that mark
+// a device as being managed by something other than this driver to a human
+// readable description.
+var foreignSignatureTypes = map[string]string{
+	"LVM1_member":       "LVM physical volume",
+	"LVM2_member":       "LVM physical volume",
+	"linux_raid_member": "mdraid member",
+	"isw_raid_member":   "Intel RAID member",
+	"ddf_raid_member":   "DDF RAID member",
+	"bcache":            "bcache device",
+	"zfs_member":        "ZFS pool member",
+}
+
+// parseForeignSignatures returns descriptions of the foreign signatures found
+// in the output of `blkid --probe --output export`.
+func parseForeignSignatures(output string) []string {
+	var signatures []string
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		i := strings.Index(line, "=")
+		if i < 0 {
+			continue
+		}
+
+		key, value := line[:i], line[i+1:]
+		switch key {
+		case "PTTYPE":
+			signatures = append(signatures, fmt.Sprintf("%s partition table", value))
+		case "TYPE":
+			if desc, ok := foreignSignatureTypes[value]; ok {
+				signatures = append(signatures, fmt.Sprintf("%s (%s)", desc, value))
+			}
+		}
+	}
+	return signatures
+}
+
+// allowForeignSignatures returns whether the volume context allows staging
+// devices with foreign signatures.
+func allowForeignSignatures(volumeContext map[string]string) (bool, error) {
+	value, ok := volumeContext[AllowForeignSignaturesAttribute]
+	if !ok {
+		return false, nil
+	}
+
+	allow, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid value %q for %s: must be a boolean", value, AllowForeignSignaturesAttribute)
+	}
+	return allow, nil
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseForeignSignatures(t *testing.T) {
+	tests := []struct {
+		name           string
+		output         string
+		wantSignatures []string
+	}{
+		{
+			name:   "filesystem",
+			output: "UUID=0f1c1a4e-8f5a-4bd6-9b5e-2f9c0d3b8a11\nVERSION=1.0\nBLOCK_SIZE=4096\nTYPE=ext4\nUSAGE=filesystem\n",
+		},
+		{
+			name:   "luks",
+			output: "UUID=6d1e7c3a-1f2b-4c5d-8e9f-0a1b2c3d4e5f\nVERSION=2\nTYPE=crypto_LUKS\nUSAGE=crypto\n",
+		},
+		{
+			name:           "gpt partition table",
+			output:         "PTUUID=3f6a2c1e-7b4d-4e8f-9a0b-1c2d3e4f5a6b\nPTTYPE=gpt\n",
+			wantSignatures: []string{"gpt partition table"},
+		},
+		{
+			name:           "mbr partition table",
+			output:         "PTUUID=5e3c2a1b\nPTTYPE=dos\n",
+			wantSignatures: []string{"dos partition table"},
+		},
+		{
+			name:           "lvm",
+			output:         "UUID=Hy7pXe-2kAb-Ws3L-9cDe-Fg4H-iJ5K-lMn6Op\nVERSION=LVM2 001\nTYPE=LVM2_member\nUSAGE=raid\n",
+			wantSignatures: []string{"LVM physical volume (LVM2_member)"},
+		},
+		{
+			name:           "mdraid",
+			output:         "UUID=a1b2c3d4-e5f6-a7b8-c9d0-e1f2a3b4c5d6\nTYPE=linux_raid_member\nUSAGE=raid\n",
+			wantSignatures: []string{"mdraid member (linux_raid_member)"},
+		},
+		{
+			name:           "bcache",
+			output:         "UUID=9f8e7d6c-5b4a-3928-1706-f5e4d3c2b1a0\nTYPE=bcache\n",
+			wantSignatures: []string{"bcache device (bcache)"},
+		},
+		{
+			name:           "zfs",
+			output:         "LABEL=tank\nUUID=1234567890\nTYPE=zfs_member\nUSAGE=filesystem\n",
+			wantSignatures: []string{"ZFS pool member (zfs_member)"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := parseForeignSignatures(test.output)
+			if diff := cmp.Diff(test.wantSignatures, got); diff != "" {
+				t.Errorf("signatures mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAllowForeignSignatures(t *testing.T) {
+	tests := []struct {
+		name          string
+		volumeContext map[string]string
+		wantAllow     bool
+		wantErr       bool
+	}{
+		{
+			name: "not set",
+		},
+		{
+			name:          "allowed",
+			volumeContext: map[string]string{AllowForeignSignaturesAttribute: "true"},
+			wantAllow:     true,
+		},
+		{
+			name:          "disallowed",
+			volumeContext: map[string]string{AllowForeignSignaturesAttribute: "false"},
+		},
+		{
+			name:          "invalid",
+			volumeContext: map[string]string{AllowForeignSignaturesAttribute: "yes please"},
+			wantErr:       true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			allow, err := allowForeignSignatures(test.volumeContext)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error: %t", err, test.wantErr)
+			}
+			if allow != test.wantAllow {
+				t.Errorf("got allow %t, want %t", allow, test.wantAllow)
+			}
+		})
+	}
+}
@@ -36,6 +36,7 @@ import (
 	"golang.org/x/oauth2"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
 )
 
 const (
@@ -43,6 +44,10 @@ const (
 	// system for the canonical, official name of this plugin
 	DefaultDriverName        = "dobs.csi.digitalocean.com"
 	defaultWaitActionTimeout = 1 * time.Minute
+
+	// DefaultDeviceWaitTimeout is the default time the node plugin waits for
+	// the device of an attached volume to appear.
+	DefaultDeviceWaitTimeout = 30 * time.Second
 )
 
 var (
@@ -62,14 +67,23 @@ type Driver struct {
 	// publishInfoVolumeName is used to pass the volume name from
 	// `ControllerPublishVolume` to `NodeStageVolume or `NodePublishVolume`
 	publishInfoVolumeName string
-
-	endpoint          string
-	debugAddr         string
-	hostID            func() string
-	region            string
-	doTag             string
-	isController      bool
-	waitActionTimeout time.Duration
+	// publishInfoVolumeID is used to pass the volume ID the controller
+	// attached to the node so that the node can verify the identity of the
+	// device before formatting it
+	publishInfoVolumeID string
+
+	endpoint              string
+	debugAddr             string
+	hostID                func() string
+	region                string
+	regions               []string
+	topologyMode          string
+	doTag                 string
+	isController          bool
+	waitActionTimeout     time.Duration
+	deviceWaitTimeout     time.Duration
+	maxVolumesPerNode     int
+	listUnownedResources  bool
 
 	srv     *grpc.Server
 	httpSrv *http.Server
@@ -84,6 +98,14 @@ type Driver struct {
 	tags           godo.TagsService
 
 	healthChecker *HealthChecker
+	metrics       *metrics
+
+	// dropletLocks serializes attaching and detaching volumes per droplet
+	dropletLocks *dropletLocks
+	// actions tracks volume actions that are still in progress
+	actions *actionTracker
+	// actionWatcher polls the status of the actions being waited on
+	actionWatcher *actionWatcher
 
 	// ready defines whether the driver is ready to function. This value will
 	// be used by the `Identity` service via the `Probe()` method.
@@ -91,20 +113,75 @@ type Driver struct {
 	ready   bool
 }
 
+// NewDriverParams defines the parameters that can be passed to NewDriver.
+type NewDriverParams struct {
+	Endpoint   string
+	Token      string
+	URL        string
+	Region     string
+	DOTag      string
+	DriverName string
+	DebugAddr  string
+	// APIRateLimit is the number of DigitalOcean API requests per second the
+	// driver is allowed to send. Zero disables client-side rate limiting.
+	APIRateLimit float64
+	// APIRateBurst is the maximum number of DigitalOcean API requests that
+	// can be sent at once when APIRateLimit is set.
+	APIRateBurst int
+	// APIMaxRetries is the number of times a rate limited or, for idempotent
+	// requests, failed DigitalOcean API request is retried.
+	APIMaxRetries int
+	// ActionStateFile is the file in-progress volume actions are persisted
+	// to. If empty, actions are only tracked in memory.
+	ActionStateFile string
+	// DeviceWaitTimeout is how long the node plugin waits for the device of
+	// an attached volume to appear.
+	DeviceWaitTimeout time.Duration
+	// Regions are additional regions the controller creates and manages
+	// volumes in besides Region (or the region of the droplet).
+	Regions []string
+	// TopologyMode selects the keys the region is published under in the
+	// topology of nodes and volumes. Defaults to DefaultTopologyMode.
+	TopologyMode string
+	// MaxVolumesPerNode is the number of volumes that can be attached to a
+	// node, including those not managed through CSI. Defaults to
+	// DefaultMaxVolumesPerNode.
+	MaxVolumesPerNode int
+	// ListUnownedResources makes ListVolumes and ListSnapshots return all
+	// volumes and snapshots rather than only those tagged with DOTag.
+	ListUnownedResources bool
+}
+
 // NewDriver returns a CSI plugin that contains the necessary gRPC
 // interfaces to interact with Kubernetes over unix domain sockets for
 // managing DigitalOcean Block Storage
-func NewDriver(ep, token, url, region, doTag, driverName, debugAddr string) (*Driver, error) {
+func NewDriver(p NewDriverParams) (*Driver, error) {
+	driverName := p.DriverName
 	if driverName == "" {
 		driverName = DefaultDriverName
 	}
 
 	tokenSource := oauth2.StaticTokenSource(&oauth2.Token{
-		AccessToken: token,
+		AccessToken: p.Token,
 	})
 	oauthClient := oauth2.NewClient(context.Background(), tokenSource)
 
+	m := newMetrics()
+	oauthClient.Transport = &instrumentedTransport{
+		base:    oauthClient.Transport,
+		metrics: m,
+	}
+
+	topologyMode := p.TopologyMode
+	if topologyMode == "" {
+		topologyMode = DefaultTopologyMode
+	}
+	if err := validateTopologyMode(topologyMode); err != nil {
+		return nil, err
+	}
+
 	var hostID string
+	region := p.Region
 	if region == "" {
 		all, err := metadata.NewClient().Metadata()
 		if err != nil {
@@ -115,8 +192,15 @@ func NewDriver(ep, token, url, region, doTag, driverName, debugAddr string) (*Dr
 		hostID = strconv.Itoa(all.DropletID)
 	}
 
+	regions := []string{region}
+	for _, r := range p.Regions {
+		if r != "" && r != region {
+			regions = append(regions, r)
+		}
+	}
+
 	opts := []godo.ClientOpt{}
-	opts = append(opts, godo.SetBaseURL(url))
+	opts = append(opts, godo.SetBaseURL(p.URL))
 
 	if version == "" {
 		version = "dev"
@@ -136,29 +220,53 @@ func NewDriver(ep, token, url, region, doTag, driverName, debugAddr string) (*Dr
 		"version": version,
 	})
 
+	limiter := newAPILimiter(p.APIRateLimit, p.APIRateBurst, p.APIMaxRetries, log)
+
+	actions, err := newActionTracker(p.ActionStateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	maxVolumesPerNode := p.MaxVolumesPerNode
+	if maxVolumesPerNode <= 0 {
+		maxVolumesPerNode = DefaultMaxVolumesPerNode
+	}
+
+	storageActions := &limitedStorageActionsService{StorageActionsService: doClient.StorageActions, limiter: limiter}
+
 	return &Driver{
 		name:                  driverName,
 		publishInfoVolumeName: driverName + "/volume-name",
+		publishInfoVolumeID:   driverName + "/volume-id",
 
-		doTag:     doTag,
-		endpoint:  ep,
-		debugAddr: debugAddr,
+		doTag:     p.DOTag,
+		endpoint:  p.Endpoint,
+		debugAddr: p.DebugAddr,
 		hostID:    func() string { return hostID },
 		region:    region,
+		regions:   regions,
 		mounter:   newMounter(log),
 		log:       log,
 		// we're assuming only the controller has a non-empty token.
-		isController:      token != "",
-		waitActionTimeout: defaultWaitActionTimeout,
-
-		storage:        doClient.Storage,
-		storageActions: doClient.StorageActions,
-		droplets:       doClient.Droplets,
-		snapshots:      doClient.Snapshots,
+		isController:          p.Token != "",
+		topologyMode:          topologyMode,
+		waitActionTimeout:     defaultWaitActionTimeout,
+		deviceWaitTimeout:     p.DeviceWaitTimeout,
+		maxVolumesPerNode:     maxVolumesPerNode,
+		listUnownedResources:  p.ListUnownedResources,
+
+		storage:        &limitedStorageService{StorageService: doClient.Storage, limiter: limiter},
+		storageActions: storageActions,
+		droplets:       &limitedDropletsService{DropletsService: doClient.Droplets, limiter: limiter},
+		snapshots:      &limitedSnapshotsService{SnapshotsService: doClient.Snapshots, limiter: limiter},
 		account:        doClient.Account,
 		tags:           doClient.Tags,
 
 		healthChecker: healthChecker,
+		metrics:       m,
+		dropletLocks:  newDropletLocks(),
+		actions:       actions,
+		actionWatcher: newActionWatcher(storageActions, log),
 	}, nil
 }
 
@@ -191,12 +299,14 @@ func (d *Driver) Run(ctx context.Context) error {
 		return fmt.Errorf("failed to listen: %v", err)
 	}
 
-	// log response errors for better observability
+	// log response errors and record metrics for better observability
 	errHandler := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
 		resp, err := handler(ctx, req)
 		if err != nil {
 			d.log.WithError(err).WithField("method", info.FullMethod).Error("method failed")
 		}
+		d.metrics.observeRPC(info.FullMethod, status.Code(err).String(), time.Since(start))
 		return resp, err
 	}
 
@@ -204,7 +314,7 @@ func (d *Driver) Run(ctx context.Context) error {
 	// something is wrong in the logs. Only check if the driver is running with
 	// a token (i.e: controller)
 	if d.isController {
-		details, err := d.checkLimit(context.Background())
+		details, err := d.checkLimit(context.Background(), d.region)
 		if err != nil {
 			return fmt.Errorf("failed to check volumes limits on startup: %s", err)
 		}
@@ -214,9 +324,13 @@ func (d *Driver) Run(ctx context.Context) error {
 				"num_volumes": details.numVolumes,
 			}).Warn("CSI plugin will not function correctly, please resolve volume limit")
 		}
+	}
 
-		if d.debugAddr != "" {
-			mux := http.NewServeMux()
+	if d.debugAddr != "" {
+		mux := http.NewServeMux()
+		// the health check talks to the DigitalOcean API, which only the
+		// controller has a token for
+		if d.isController {
 			mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 				err := d.healthChecker.Check(r.Context())
 				if err != nil {
@@ -226,10 +340,11 @@ func (d *Driver) Run(ctx context.Context) error {
 				}
 				w.WriteHeader(http.StatusOK)
 			})
-			d.httpSrv = &http.Server{
-				Addr:    d.debugAddr,
-				Handler: mux,
-			}
+		}
+		mux.Handle("/metrics", d.metrics)
+		d.httpSrv = &http.Server{
+			Addr:    d.debugAddr,
+			Handler: mux,
 		}
 	}
 
@@ -244,6 +359,10 @@ func (d *Driver) Run(ctx context.Context) error {
 		"http_addr": d.debugAddr,
 	}).Info("starting server")
 
+	if d.isController {
+		go d.runCloneSnapshotSweeper(ctx)
+	}
+
 	var eg errgroup.Group
 	if d.httpSrv != nil {
 		eg.Go(func() error {
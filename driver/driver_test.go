@@ -25,6 +25,7 @@ import (
 	"os"
 	"sort"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -80,10 +81,17 @@ func TestDriverSuite(t *testing.T) {
 		}
 	}
 
+	mounter := &fakeMounter{
+		mounted: map[string]string{},
+		volumes: volumes,
+	}
+
 	dropletIdx := 1
 	driver := &Driver{
-		name:     DefaultDriverName,
-		endpoint: endpoint,
+		name:                  DefaultDriverName,
+		publishInfoVolumeName: DefaultDriverName + "/volume-name",
+		publishInfoVolumeID:   DefaultDriverName + "/volume-id",
+		endpoint:              endpoint,
 		hostID: func() string {
 			// Distribute requests across multiple nodes so that we do not run
 			// into the max-volumes-per-node limit.
@@ -94,10 +102,9 @@ func TestDriverSuite(t *testing.T) {
 		doTag:             doTag,
 		region:            "nyc3",
 		waitActionTimeout: defaultWaitActionTimeout,
-		mounter: &fakeMounter{
-			mounted: map[string]string{},
-		},
-		log: logrus.New().WithField("test_enabed", true),
+		maxVolumesPerNode: DefaultMaxVolumesPerNode,
+		mounter:           mounter,
+		log:               logrus.New().WithField("test_enabed", true),
 
 		storage: &fakeStorageDriver{
 			volumes:   volumes,
@@ -115,6 +122,9 @@ func TestDriverSuite(t *testing.T) {
 		},
 		account: &fakeAccountDriver{},
 		tags:    &fakeTagsDriver{},
+
+		dropletLocks: newDropletLocks(),
+		actions:      &actionTracker{actions: map[string]trackedAction{}},
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -135,6 +145,14 @@ func TestDriverSuite(t *testing.T) {
 	cfg.IDGen = &idGenerator{}
 	cfg.IdempotentCount = 5
 	cfg.TestNodeVolumeAttachLimit = true
+	// the fake mounter does not create mount points, so the paths it has
+	// mounted something to are reported as directories
+	cfg.CheckPath = func(path string) (sanity.PathKind, error) {
+		if _, ok := mounter.mounted[path]; ok {
+			return sanity.PathIsDir, nil
+		}
+		return sanity.PathIsNotFound, nil
+	}
 	sanity.Test(t, cfg)
 
 	cancel()
@@ -219,6 +237,7 @@ func (f *fakeStorageDriver) CreateVolume(ctx context.Context, req *godo.VolumeCr
 		Name:          req.Name,
 		Description:   req.Description,
 		SizeGigaBytes: req.SizeGigaBytes,
+		Tags:          req.Tags,
 	}
 
 	f.volumes[id] = vol
@@ -270,6 +289,7 @@ func (f *fakeStorageDriver) CreateSnapshot(crx context.Context, req *godo.Snapsh
 
 	id := randString(10)
 	snap := createGodoSnapshot(id, req.Name, req.VolumeID)
+	snap.Tags = req.Tags
 
 	f.snapshots[id] = snap
 
@@ -284,6 +304,7 @@ func (f *fakeStorageDriver) DeleteSnapshot(ctx context.Context, id string) (*god
 type fakeStorageActionsDriver struct {
 	volumes  map[string]*godo.Volume
 	droplets map[int]*godo.Droplet
+	actions  map[string][]godo.Action
 }
 
 func (f *fakeStorageActionsDriver) Attach(ctx context.Context, volumeID string, dropletID int) (*godo.Action, *godo.Response, error) {
@@ -304,11 +325,11 @@ func (f *fakeStorageActionsDriver) Attach(ctx context.Context, volumeID string,
 		return nil, resp, errors.New("droplet was not found")
 	}
 
-	if len(droplet.VolumeIDs) >= maxVolumesPerNode {
+	if len(droplet.VolumeIDs) >= DefaultMaxVolumesPerNode {
 		resp.Response = &http.Response{
 			StatusCode: http.StatusUnprocessableEntity,
 		}
-		return nil, resp, errors.New(maxVolumesPerDropletErrorMessage)
+		return nil, resp, fmt.Errorf("cannot attach more than %d volumes to a single Droplet", DefaultMaxVolumesPerNode)
 	}
 	droplet.VolumeIDs = append(droplet.VolumeIDs, volumeID)
 
@@ -360,7 +381,8 @@ func (f *fakeStorageActionsDriver) Get(ctx context.Context, volumeID string, act
 }
 
 func (f *fakeStorageActionsDriver) List(ctx context.Context, volumeID string, opt *godo.ListOptions) ([]godo.Action, *godo.Response, error) {
-	return nil, godoResponseWithMeta(0), nil
+	actions := f.actions[volumeID]
+	return actions, godoResponseWithMeta(len(actions)), nil
 }
 
 func (f *fakeStorageActionsDriver) Resize(ctx context.Context, volumeID string, sizeGigabytes int, regionSlug string) (*godo.Action, *godo.Response, error) {
@@ -510,9 +532,27 @@ func (f *fakeSnapshotsDriver) Delete(context.Context, string) (*godo.Response, e
 
 type fakeMounter struct {
 	mounted map[string]string
+	// volumes are the volumes whose devices report their ID, looked up by
+	// the name in the device path
+	volumes map[string]*godo.Volume
+	// readOnlyDevices holds the read-only flags of block devices
+	readOnlyDevices map[string]bool
 }
 
-func (f *fakeMounter) Format(source string, fsType string, context LuksContext) error {
+func (f *fakeMounter) WaitForDevice(ctx context.Context, devicePath string) error {
+	return nil
+}
+
+func (f *fakeMounter) GetDeviceIdentifiers(devicePath string) ([]string, error) {
+	for _, vol := range f.volumes {
+		if devicePath == getDeviceByIDPath(vol.Name) {
+			return []string{vol.ID}, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeMounter) Format(source string, fsType string, mkfsOptions []string, context LuksContext) error {
 	return nil
 }
 
@@ -521,6 +561,34 @@ func (f *fakeMounter) Mount(source string, target string, fsType string, context
 	return nil
 }
 
+func (f *fakeMounter) SetBlockDeviceReadOnly(devicePath string, readOnly bool) error {
+	if f.readOnlyDevices != nil {
+		f.readOnlyDevices[devicePath] = readOnly
+	}
+	return nil
+}
+
+func (f *fakeMounter) IsBlockDeviceReadOnly(devicePath string) (bool, error) {
+	return f.readOnlyDevices[devicePath], nil
+}
+
+func (f *fakeMounter) GetPublishedBlockDevice(target string) (string, error) {
+	if source := f.mounted[target]; strings.HasPrefix(source, "/dev/") {
+		return source, nil
+	}
+	return "", nil
+}
+
+func (f *fakeMounter) GetBindMountTargets(source string, isDevice bool) ([]string, error) {
+	var targets []string
+	for target, mountSource := range f.mounted {
+		if mountSource == source {
+			targets = append(targets, target)
+		}
+	}
+	return targets, nil
+}
+
 func (f *fakeMounter) Unmount(target string, context LuksContext) error {
 	delete(f.mounted, target)
 	return nil
@@ -534,10 +602,34 @@ func (f *fakeMounter) GetDeviceName(_ mount.Interface, mountPath string) (string
 	return "", nil
 }
 
+func (f *fakeMounter) RotateLuksKey(source string, context LuksContext) error {
+	return nil
+}
+
+func (f *fakeMounter) ResizeLuksMapping(devicePath string, luksContext LuksContext) error {
+	return nil
+}
+
 func (f *fakeMounter) IsFormatted(source string, context LuksContext) (bool, error) {
 	return true, nil
 }
 
+func (f *fakeMounter) GetForeignSignatures(source string) ([]string, error) {
+	return nil, nil
+}
+
+func (f *fakeMounter) FindPartition(disk string, selector partitionSelector) (string, error) {
+	return fmt.Sprintf("%s-part%s", disk, selector), nil
+}
+
+func (f *fakeMounter) CountUnmanagedVolumes(driverName string) (int, error) {
+	return 0, nil
+}
+
+func (f *fakeMounter) GrowPartition(devicePath string) error {
+	return nil
+}
+
 func (f *fakeMounter) IsMounted(target string) (bool, error) {
 	_, ok := f.mounted[target]
 	return ok, nil
@@ -559,12 +651,17 @@ func (f *fakeMounter) IsBlockDevice(volumePath string) (bool, error) {
 	return false, nil
 }
 
+func (f *fakeMounter) GetVolumeCondition(volumePath, stagingPath string) (volumeCondition, error) {
+	return volumeCondition{message: "volume is healthy"}, nil
+}
+
 func createGodoSnapshot(id, name, volumeID string) *godo.Snapshot {
 	return &godo.Snapshot{
-		ID:         id,
-		Name:       name,
-		ResourceID: volumeID,
-		Created:    time.Now().UTC().Format(time.RFC3339),
+		ID:            id,
+		Name:          name,
+		ResourceID:    volumeID,
+		SizeGigaBytes: 1,
+		Created:       time.Now().UTC().Format(time.RFC3339),
 	}
 }
 
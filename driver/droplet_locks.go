@@ -0,0 +1,94 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"sync"
+)
+
+// dropletLocks serializes volume actions per droplet. The DigitalOcean API
+// rejects a volume action while another one is pending on the same droplet,
+// so attaches and detaches for a droplet are queued in-process instead of
+// bouncing off the API. Unlike a plain mutex, waiting for a lock can be
+// aborted through a context.
+type dropletLocks struct {
+	mu    sync.Mutex
+	locks map[int]*dropletLock
+}
+
+type dropletLock struct {
+	// sem is a semaphore of size one. Blocked senders are served roughly in
+	// the order they arrived.
+	sem chan struct{}
+	// refs counts the holder and the waiters of the lock so that it can be
+	// dropped once no longer used.
+	refs int
+}
+
+func newDropletLocks() *dropletLocks {
+	return &dropletLocks{
+		locks: map[int]*dropletLock{},
+	}
+}
+
+// lock blocks until the lock for the given droplet is acquired or the context
+// is done. It returns whether the caller had to wait for another operation.
+func (l *dropletLocks) lock(ctx context.Context, dropletID int) (bool, error) {
+	l.mu.Lock()
+	dl, ok := l.locks[dropletID]
+	if !ok {
+		dl = &dropletLock{sem: make(chan struct{}, 1)}
+		l.locks[dropletID] = dl
+	}
+	dl.refs++
+	l.mu.Unlock()
+
+	select {
+	case dl.sem <- struct{}{}:
+		return false, nil
+	default:
+	}
+
+	select {
+	case dl.sem <- struct{}{}:
+		return true, nil
+	case <-ctx.Done():
+		l.release(dropletID, dl)
+		return true, ctx.Err()
+	}
+}
+
+// unlock releases the lock for the given droplet.
+func (l *dropletLocks) unlock(dropletID int) {
+	l.mu.Lock()
+	dl := l.locks[dropletID]
+	l.mu.Unlock()
+
+	<-dl.sem
+	l.release(dropletID, dl)
+}
+
+func (l *dropletLocks) release(dropletID int, dl *dropletLock) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	dl.refs--
+	if dl.refs == 0 {
+		delete(l.locks, dropletID)
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDropletLocksSerializePerDroplet(t *testing.T) {
+	locks := newDropletLocks()
+	ctx := context.Background()
+
+	var (
+		mu      sync.Mutex
+		active  = map[int]int{}
+		overlap bool
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < 20; i++ {
+		dropletID := i % 2
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := locks.lock(ctx, dropletID); err != nil {
+				t.Errorf("got error %q, want none", err)
+				return
+			}
+			defer locks.unlock(dropletID)
+
+			mu.Lock()
+			active[dropletID]++
+			if active[dropletID] > 1 {
+				overlap = true
+			}
+			mu.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			mu.Lock()
+			active[dropletID]--
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if overlap {
+		t.Error("operations for the same droplet overlapped")
+	}
+	if len(locks.locks) != 0 {
+		t.Errorf("got %d leftover locks, want none", len(locks.locks))
+	}
+}
+
+func TestDropletLocksContextCanceled(t *testing.T) {
+	locks := newDropletLocks()
+
+	waited, err := locks.lock(context.Background(), 1)
+	if err != nil || waited {
+		t.Fatalf("got (%t, %v), want (false, nil)", waited, err)
+	}
+
+	// other droplets are not blocked
+	if _, err := locks.lock(context.Background(), 2); err != nil {
+		t.Fatalf("got error %q locking another droplet, want none", err)
+	}
+	locks.unlock(2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	waited, err = locks.lock(ctx, 1)
+	if err != context.DeadlineExceeded || !waited {
+		t.Fatalf("got (%t, %v), want (true, %v)", waited, err, context.DeadlineExceeded)
+	}
+
+	locks.unlock(1)
+	if len(locks.locks) != 0 {
+		t.Errorf("got %d leftover locks, want none", len(locks.locks))
+	}
+}
@@ -22,8 +22,10 @@ import (
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"io/ioutil"
+	"math"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 )
 
@@ -40,8 +42,37 @@ const (
 	// to `NodeStageVolume`
 	LuksKeySizeAttribute = DefaultDriverName + "/luks-key-size"
 
+	// LuksTypeAttribute is used to pass the luks format version (luks1 or luks2) to
+	// `NodeStageVolume`
+	LuksTypeAttribute = DefaultDriverName + "/luks-type"
+
+	// LuksPbkdfAttribute is used to pass the password-based key derivation function
+	// (pbkdf2, argon2i or argon2id) to `NodeStageVolume`
+	LuksPbkdfAttribute = DefaultDriverName + "/luks-pbkdf"
+
+	// LuksPbkdfMemoryAttribute is used to pass the memory cost of the argon2 key
+	// derivation functions in KiB to `NodeStageVolume`
+	LuksPbkdfMemoryAttribute = DefaultDriverName + "/luks-pbkdf-memory"
+
+	// LuksPbkdfIterationsAttribute is used to pass the iteration (or, for argon2, time)
+	// cost of the key derivation function to `NodeStageVolume`
+	LuksPbkdfIterationsAttribute = DefaultDriverName + "/luks-pbkdf-iterations"
+
+	// LuksSectorSizeAttribute is used to pass the encryption sector size in bytes to
+	// `NodeStageVolume`
+	LuksSectorSizeAttribute = DefaultDriverName + "/luks-sector-size"
+
+	// LuksIntegrityAttribute is used to pass the authenticated integrity algorithm to
+	// `NodeStageVolume`
+	LuksIntegrityAttribute = DefaultDriverName + "/luks-integrity"
+
 	// LuksKeyAttribute is the key of the luks key used in the map of secrets passed from the CO
 	LuksKeyAttribute = "luksKey"
+
+	// LuksKeyPreviousAttribute is the key of the previous luks key used in the map of secrets
+	// passed from the CO. If set, the key is replaced by the one in LuksKeyAttribute during
+	// `NodeStageVolume`
+	LuksKeyPreviousAttribute = "luksKeyPrevious"
 )
 
 type VolumeLifecycle string
@@ -51,15 +82,35 @@ const (
 	VolumeLifecycleNodePublishVolume   VolumeLifecycle = "NodePublishVolume"
 	VolumeLifecycleNodeUnstageVolume   VolumeLifecycle = "NodeUnstageVolume"
 	VolumeLifecycleNodeUnpublishVolume VolumeLifecycle = "NodeUnpublishVolume"
+	VolumeLifecycleNodeExpandVolume    VolumeLifecycle = "NodeExpandVolume"
+	VolumeLifecycleCreateVolume        VolumeLifecycle = "CreateVolume"
 )
 
+// luksKeyLocationKeyring is the key location reported by cryptsetup status for mappings whose
+// volume key is kept in the kernel keyring rather than in the dm-crypt table, which is the
+// default for luks2
+const luksKeyLocationKeyring = "keyring"
+
+// errLuksKeyRequired is returned when a luks mapping cannot be resized without the luks key
+var errLuksKeyRequired = errors.New("the volume key of the luks mapping is kept in the kernel keyring, so resizing it requires the luks key")
+
 type LuksContext struct {
 	EncryptionEnabled bool
 	EncryptionKey     string
-	EncryptionCipher  string
-	EncryptionKeySize string
-	VolumeName        string
-	VolumeLifecycle   VolumeLifecycle
+	// PreviousEncryptionKey is the key EncryptionKey replaces, if any
+	PreviousEncryptionKey string
+	EncryptionCipher      string
+	EncryptionKeySize     string
+	// the following settings are optional and default to those of the
+	// cryptsetup binary if empty
+	EncryptionType  string
+	Pbkdf           string
+	PbkdfMemory     string
+	PbkdfIterations string
+	SectorSize      string
+	Integrity       string
+	VolumeName      string
+	VolumeLifecycle VolumeLifecycle
 }
 
 func (ctx *LuksContext) validate() error {
@@ -79,7 +130,8 @@ func (ctx *LuksContext) validate() error {
 	if ctx.VolumeName == "" {
 		errorMsg = appendFn("no volume name provided", errorMsg)
 	}
-	if ctx.EncryptionKey == "" {
+	// the key is only passed to the node through the staging secret
+	if ctx.EncryptionKey == "" && ctx.VolumeLifecycle != VolumeLifecycleCreateVolume {
 		errorMsg = appendFn("no encryption key provided", errorMsg)
 	}
 	if ctx.EncryptionCipher == "" {
@@ -88,12 +140,73 @@ func (ctx *LuksContext) validate() error {
 	if ctx.EncryptionKeySize == "" {
 		errorMsg = appendFn("no encryption key size provided", errorMsg)
 	}
+
+	isLuks2 := ctx.EncryptionType == "luks2"
+	isArgon2 := ctx.Pbkdf == "argon2i" || ctx.Pbkdf == "argon2id"
+	switch ctx.EncryptionType {
+	case "", "luks1", "luks2":
+	default:
+		errorMsg = appendFn(fmt.Sprintf("unsupported luks type %q", ctx.EncryptionType), errorMsg)
+	}
+	switch ctx.Pbkdf {
+	case "", "pbkdf2":
+	case "argon2i", "argon2id":
+		if !isLuks2 {
+			errorMsg = appendFn(fmt.Sprintf("pbkdf %q requires luks type luks2", ctx.Pbkdf), errorMsg)
+		}
+	default:
+		errorMsg = appendFn(fmt.Sprintf("unsupported pbkdf %q", ctx.Pbkdf), errorMsg)
+	}
+	if ctx.PbkdfMemory != "" {
+		if !isArgon2 {
+			errorMsg = appendFn("pbkdf memory cost requires pbkdf argon2i or argon2id", errorMsg)
+		} else if !isIntInRange(ctx.PbkdfMemory, 32, 4194304) {
+			errorMsg = appendFn(fmt.Sprintf("pbkdf memory cost %q must be between 32 and 4194304 KiB", ctx.PbkdfMemory), errorMsg)
+		}
+	}
+	if ctx.PbkdfIterations != "" {
+		minIterations := int64(1000)
+		if isArgon2 {
+			minIterations = 4
+		}
+		if !isIntInRange(ctx.PbkdfIterations, minIterations, math.MaxUint32) {
+			errorMsg = appendFn(fmt.Sprintf("pbkdf iterations %q must be at least %d", ctx.PbkdfIterations, minIterations), errorMsg)
+		}
+	}
+	if ctx.SectorSize != "" {
+		switch {
+		case !isLuks2:
+			errorMsg = appendFn("sector size requires luks type luks2", errorMsg)
+		case ctx.SectorSize != "512" && ctx.SectorSize != "1024" && ctx.SectorSize != "2048" && ctx.SectorSize != "4096":
+			errorMsg = appendFn(fmt.Sprintf("sector size %q must be one of 512, 1024, 2048, 4096", ctx.SectorSize), errorMsg)
+		}
+	}
+	if ctx.Integrity != "" {
+		switch {
+		case !isLuks2:
+			errorMsg = appendFn("integrity requires luks type luks2", errorMsg)
+		case ctx.Integrity != "hmac-sha256" && ctx.Integrity != "hmac-sha512":
+			errorMsg = appendFn(fmt.Sprintf("unsupported integrity algorithm %q", ctx.Integrity), errorMsg)
+		}
+	}
 	if errorMsg == "" {
 		return nil
 	}
 	return errors.New(errorMsg)
 }
 
+// validateLuksParameters validates the luks settings among the given CreateVolume parameters so
+// that invalid settings are rejected when the volume is provisioned rather than when it is
+// staged.
+func validateLuksParameters(volumeName string, parameters map[string]string) error {
+	context := map[string]string{PublishInfoVolumeName: volumeName}
+	for key, value := range parameters {
+		context[key] = value
+	}
+	ctx := getLuksContext(nil, context, VolumeLifecycleCreateVolume)
+	return ctx.validate()
+}
+
 func getLuksContext(secrets map[string]string, context map[string]string, lifecycle VolumeLifecycle) LuksContext {
 	if context[LuksEncryptedAttribute] != "true" {
 		return LuksContext{
@@ -103,17 +216,31 @@ func getLuksContext(secrets map[string]string, context map[string]string, lifecy
 	}
 
 	luksKey := secrets[LuksKeyAttribute]
+	luksKeyPrevious := secrets[LuksKeyPreviousAttribute]
 	luksCipher := context[LuksCipherAttribute]
 	luksKeySize := context[LuksKeySizeAttribute]
+	luksType := context[LuksTypeAttribute]
+	luksPbkdf := context[LuksPbkdfAttribute]
+	luksPbkdfMemory := context[LuksPbkdfMemoryAttribute]
+	luksPbkdfIterations := context[LuksPbkdfIterationsAttribute]
+	luksSectorSize := context[LuksSectorSizeAttribute]
+	luksIntegrity := context[LuksIntegrityAttribute]
 	volumeName := context[PublishInfoVolumeName]
 
 	return LuksContext{
-		EncryptionEnabled: true,
-		EncryptionKey:     luksKey,
-		EncryptionCipher:  luksCipher,
-		EncryptionKeySize: luksKeySize,
-		VolumeName:        volumeName,
-		VolumeLifecycle:   lifecycle,
+		EncryptionEnabled:     true,
+		EncryptionKey:         luksKey,
+		PreviousEncryptionKey: luksKeyPrevious,
+		EncryptionCipher:      luksCipher,
+		EncryptionKeySize:     luksKeySize,
+		EncryptionType:        luksType,
+		Pbkdf:                 luksPbkdf,
+		PbkdfMemory:           luksPbkdfMemory,
+		PbkdfIterations:       luksPbkdfIterations,
+		SectorSize:            luksSectorSize,
+		Integrity:             luksIntegrity,
+		VolumeName:            volumeName,
+		VolumeLifecycle:       lifecycle,
 	}
 }
 
@@ -141,8 +268,9 @@ func luksFormat(source string, mkfsCmd string, mkfsArgs []string, ctx LuksContex
 		"--cipher", ctx.EncryptionCipher,
 		"--key-size", ctx.EncryptionKeySize,
 		"--key-file", filename,
-		"luksFormat", source,
 	}
+	cryptsetupArgs = append(cryptsetupArgs, luksFormatOptions(ctx)...)
+	cryptsetupArgs = append(cryptsetupArgs, "luksFormat", source)
 
 	log.WithFields(logrus.Fields{
 		"cmd":  cryptsetupCmd,
@@ -195,6 +323,201 @@ func luksFormat(source string, mkfsCmd string, mkfsArgs []string, ctx LuksContex
 	return nil
 }
 
+// rotates the key of the given luks volume from the previous to the current encryption key of
+// the given context. The current key is added to a free keyslot and the previous key is removed
+// only once the current key has been proven to open the volume. Rotating is a no-op if the
+// volume is not a luks volume yet or the previous key has already been removed.
+func luksRotateKey(volume string, ctx LuksContext, log *logrus.Entry) error {
+	if ctx.PreviousEncryptionKey == "" || ctx.PreviousEncryptionKey == ctx.EncryptionKey {
+		return nil
+	}
+
+	isLuks, err := isLuks(volume)
+	if err != nil {
+		return err
+	}
+	if !isLuks {
+		return nil
+	}
+
+	keyFile, err := writeLuksKey(ctx.EncryptionKey, log)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		e := os.Remove(keyFile)
+		if e != nil {
+			log.Errorf("cannot delete temporary file %s: %s", keyFile, e.Error())
+		}
+	}()
+
+	previousKeyFile, err := writeLuksKey(ctx.PreviousEncryptionKey, log)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		e := os.Remove(previousKeyFile)
+		if e != nil {
+			log.Errorf("cannot delete temporary file %s: %s", previousKeyFile, e.Error())
+		}
+	}()
+
+	log = log.WithField("volume", volume)
+
+	previousKeyValid, err := luksTestKey(volume, previousKeyFile, log)
+	if err != nil {
+		return err
+	}
+	if !previousKeyValid {
+		log.Info("previous luks key does not open the volume, assuming it has already been rotated")
+		return nil
+	}
+
+	keyValid, err := luksTestKey(volume, keyFile, log)
+	if err != nil {
+		return err
+	}
+	if !keyValid {
+		if err := luksAddKey(volume, previousKeyFile, keyFile, ctx, log); err != nil {
+			return err
+		}
+
+		keyValid, err = luksTestKey(volume, keyFile, log)
+		if err != nil {
+			return err
+		}
+		if !keyValid {
+			return errors.New("luks key was added but does not open the volume; keeping the previous key")
+		}
+	}
+
+	if err := luksRemoveKey(volume, previousKeyFile, log); err != nil {
+		return err
+	}
+
+	log.Info("luks key rotated")
+	return nil
+}
+
+// checks whether the key in the given key file opens the given luks volume
+func luksTestKey(volume string, keyFile string, log *logrus.Entry) (bool, error) {
+	cryptsetupCmd, err := getCryptsetupCmd()
+	if err != nil {
+		return false, err
+	}
+	cryptsetupArgs := []string{
+		"--batch-mode",
+		"luksOpen",
+		"--test-passphrase",
+		"--key-file", keyFile,
+		volume,
+	}
+	log.WithFields(logrus.Fields{
+		"cmd":  cryptsetupCmd,
+		"args": cryptsetupArgs,
+	}).Info("executing cryptsetup luksOpen --test-passphrase command")
+
+	// cryptsetup exits with a non-zero exit code if no keyslot matches the key
+	out, err := exec.Command(cryptsetupCmd, cryptsetupArgs...).CombinedOutput()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return false, nil
+		}
+		return false, fmt.Errorf("cryptsetup luksOpen --test-passphrase failed: %v cmd: '%s %s' output: %q",
+			err, cryptsetupCmd, strings.Join(cryptsetupArgs, " "), string(out))
+	}
+	return true, nil
+}
+
+// adds the key in newKeyFile to a free keyslot of the given luks volume, authorized by the key
+// in keyFile. The keyslot is set up with the same key derivation settings as the volume was
+// formatted with.
+func luksAddKey(volume string, keyFile string, newKeyFile string, ctx LuksContext, log *logrus.Entry) error {
+	cryptsetupCmd, err := getCryptsetupCmd()
+	if err != nil {
+		return err
+	}
+	cryptsetupArgs := []string{
+		"--batch-mode",
+		"luksAddKey",
+		"--key-file", keyFile,
+	}
+	cryptsetupArgs = append(cryptsetupArgs, luksKeyslotOptions(ctx)...)
+	cryptsetupArgs = append(cryptsetupArgs, volume, newKeyFile)
+	log.WithFields(logrus.Fields{
+		"cmd":  cryptsetupCmd,
+		"args": cryptsetupArgs,
+	}).Info("executing cryptsetup luksAddKey command")
+
+	out, err := exec.Command(cryptsetupCmd, cryptsetupArgs...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("cryptsetup luksAddKey failed: %v cmd: '%s %s' output: %q",
+			err, cryptsetupCmd, strings.Join(cryptsetupArgs, " "), string(out))
+	}
+	return nil
+}
+
+// removes the key in the given key file from the given luks volume
+func luksRemoveKey(volume string, keyFile string, log *logrus.Entry) error {
+	cryptsetupCmd, err := getCryptsetupCmd()
+	if err != nil {
+		return err
+	}
+	cryptsetupArgs := []string{
+		"--batch-mode",
+		"luksRemoveKey",
+		volume, keyFile,
+	}
+	log.WithFields(logrus.Fields{
+		"cmd":  cryptsetupCmd,
+		"args": cryptsetupArgs,
+	}).Info("executing cryptsetup luksRemoveKey command")
+
+	out, err := exec.Command(cryptsetupCmd, cryptsetupArgs...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("cryptsetup luksRemoveKey failed: %v cmd: '%s %s' output: %q",
+			err, cryptsetupCmd, strings.Join(cryptsetupArgs, " "), string(out))
+	}
+	return nil
+}
+
+// returns the optional cryptsetup luksFormat arguments for the given context
+func luksFormatOptions(ctx LuksContext) []string {
+	args := luksKeyslotOptions(ctx)
+	if ctx.SectorSize != "" {
+		args = append(args, "--sector-size", ctx.SectorSize)
+	}
+	if ctx.Integrity != "" {
+		args = append(args, "--integrity", ctx.Integrity)
+	}
+	return args
+}
+
+// returns the optional cryptsetup arguments for the given context that apply to keyslots, i.e.
+// the luks type and the key derivation settings, so that keys added later on are derived the
+// same way as the key the volume was formatted with
+func luksKeyslotOptions(ctx LuksContext) []string {
+	var args []string
+	if ctx.EncryptionType != "" {
+		args = append(args, "--type", ctx.EncryptionType)
+	}
+	if ctx.Pbkdf != "" {
+		args = append(args, "--pbkdf", ctx.Pbkdf)
+	}
+	if ctx.PbkdfMemory != "" {
+		args = append(args, "--pbkdf-memory", ctx.PbkdfMemory)
+	}
+	if ctx.PbkdfIterations != "" {
+		args = append(args, "--pbkdf-force-iterations", ctx.PbkdfIterations)
+	}
+	return args
+}
+
+func isIntInRange(value string, min, max int64) bool {
+	i, err := strconv.ParseInt(value, 10, 64)
+	return err == nil && i >= min && i <= max
+}
+
 // prepares a luks-encrypted volume for mounting and returns the path of the mapped volume
 func luksPrepareMount(source string, ctx LuksContext, log *logrus.Entry) (string, error) {
 	filename, err := writeLuksKey(ctx.EncryptionKey, log)
@@ -346,6 +669,94 @@ func isLuksMapping(volume string) (bool, string, error) {
 	return false, "", nil
 }
 
+// grows the luks mapping with the given name to the size of the underlying device, authorized by
+// the key in the given key file. The key file may only be empty if the volume key is kept in the
+// dm-crypt table, as it is for luks1; otherwise, errLuksKeyRequired is returned.
+func luksResize(mappingName string, keyFile string, log *logrus.Entry) error {
+	cryptsetupCmd, err := getCryptsetupCmd()
+	if err != nil {
+		return err
+	}
+
+	if keyFile == "" {
+		location, err := luksKeyLocation(mappingName)
+		if err != nil {
+			return err
+		}
+		if location == luksKeyLocationKeyring {
+			return errLuksKeyRequired
+		}
+	}
+
+	cryptsetupArgs := []string{"--batch-mode", "resize"}
+	if keyFile != "" {
+		cryptsetupArgs = append(cryptsetupArgs, "--key-file", keyFile)
+	}
+	cryptsetupArgs = append(cryptsetupArgs, mappingName)
+
+	log.WithFields(logrus.Fields{
+		"cmd":  cryptsetupCmd,
+		"args": cryptsetupArgs,
+	}).Info("executing cryptsetup resize command")
+
+	out, err := exec.Command(cryptsetupCmd, cryptsetupArgs...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("cryptsetup resize failed: %v cmd: '%s %s' output: %q",
+			err, cryptsetupCmd, strings.Join(cryptsetupArgs, " "), string(out))
+	}
+	return nil
+}
+
+// returns where the volume key of the active luks mapping with the given name is kept, as
+// reported by cryptsetup status
+func luksKeyLocation(mappingName string) (string, error) {
+	cryptsetupCmd, err := getCryptsetupCmd()
+	if err != nil {
+		return "", err
+	}
+	cryptsetupArgs := []string{"status", mappingName}
+
+	out, err := exec.Command(cryptsetupCmd, cryptsetupArgs...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("cryptsetup status failed: %v cmd: '%s %s' output: %q",
+			err, cryptsetupCmd, strings.Join(cryptsetupArgs, " "), string(out))
+	}
+	return parseLuksKeyLocation(string(out)), nil
+}
+
+// parses the key location from the output of cryptsetup status. Versions of cryptsetup that do
+// not report the key location always keep the volume key in the dm-crypt table.
+func parseLuksKeyLocation(status string) string {
+	for _, statusLine := range strings.Split(status, "\n") {
+		fields := strings.SplitN(statusLine, ":", 2)
+		if len(fields) == 2 && strings.TrimSpace(fields[0]) == "key location" {
+			return strings.TrimSpace(fields[1])
+		}
+	}
+	return "dm-crypt"
+}
+
+// checks if the luks mapping with the given name is active
+func isLuksMappingActive(mappingName string) (bool, error) {
+	cryptsetupCmd, err := getCryptsetupCmd()
+	if err != nil {
+		return false, err
+	}
+	cryptsetupArgs := []string{"status", mappingName}
+
+	// cryptsetup status exits with code 0 if the mapping is active; otherwise it returns
+	// a non-zero exit code which exec.Command interprets as an error
+	out, err := exec.Command(cryptsetupCmd, cryptsetupArgs...).CombinedOutput()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return false, nil
+		}
+		return false, fmt.Errorf("cryptsetup status failed: %v cmd: '%s %s' output: %q",
+			err, cryptsetupCmd, strings.Join(cryptsetupArgs, " "), string(out))
+	}
+	return true, nil
+}
+
 func getCryptsetupCmd() (string, error) {
 	cryptsetupCmd := "cryptsetup"
 	_, err := exec.LookPath(cryptsetupCmd)
@@ -4,7 +4,14 @@ import (
 	"context"
 	"errors"
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/digitalocean/godo"
+	"github.com/google/go-cmp/cmp"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -59,6 +66,9 @@ func TestCreateLuksVolume(t *testing.T) {
 					listVolumesErr: test.listVolumesErr,
 				},
 				snapshots: &fakeSnapshotsDriver{
+					snapshots: map[string]*godo.Snapshot{
+						"snapshotId": createGodoSnapshot("snapshotId", "snapshot", "volumeId"),
+					},
 					getSnapshotErr: test.getSnapshotErr,
 				},
 				log: logrus.New().WithField("test_enabed", true),
@@ -108,3 +118,442 @@ func TestCreateLuksVolume(t *testing.T) {
 		})
 	}
 }
+
+func TestGetLuksContext(t *testing.T) {
+	volumeContext := map[string]string{
+		LuksEncryptedAttribute: "true",
+		LuksCipherAttribute:    "aes-xts-plain64",
+		LuksKeySizeAttribute:   "512",
+		PublishInfoVolumeName:  "volume",
+	}
+
+	tests := []struct {
+		name         string
+		secrets      map[string]string
+		context      map[string]string
+		wantEnabled  bool
+		wantKey      string
+		wantPrevious string
+	}{
+		{
+			name:    "not encrypted",
+			secrets: map[string]string{LuksKeyAttribute: "key"},
+			context: map[string]string{},
+		},
+		{
+			name:        "current key only",
+			secrets:     map[string]string{LuksKeyAttribute: "key"},
+			context:     volumeContext,
+			wantEnabled: true,
+			wantKey:     "key",
+		},
+		{
+			name: "current and previous key",
+			secrets: map[string]string{
+				LuksKeyAttribute:         "key",
+				LuksKeyPreviousAttribute: "previous-key",
+			},
+			context:      volumeContext,
+			wantEnabled:  true,
+			wantKey:      "key",
+			wantPrevious: "previous-key",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := getLuksContext(test.secrets, test.context, VolumeLifecycleNodeStageVolume)
+
+			if ctx.EncryptionEnabled != test.wantEnabled {
+				t.Errorf("got encryption enabled %t, want %t", ctx.EncryptionEnabled, test.wantEnabled)
+			}
+			if ctx.EncryptionKey != test.wantKey {
+				t.Errorf("got key %q, want %q", ctx.EncryptionKey, test.wantKey)
+			}
+			if ctx.PreviousEncryptionKey != test.wantPrevious {
+				t.Errorf("got previous key %q, want %q", ctx.PreviousEncryptionKey, test.wantPrevious)
+			}
+			if err := ctx.validate(); err != nil {
+				t.Errorf("got validation error %q, want none", err)
+			}
+		})
+	}
+}
+
+func TestLuksContextValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(ctx *LuksContext)
+		wantArgs []string
+		wantErr  string
+	}{
+		{
+			name: "cryptsetup defaults",
+		},
+		{
+			name: "luks2 with argon2id",
+			modify: func(ctx *LuksContext) {
+				ctx.EncryptionType = "luks2"
+				ctx.Pbkdf = "argon2id"
+				ctx.PbkdfMemory = "1048576"
+				ctx.PbkdfIterations = "4"
+				ctx.SectorSize = "4096"
+				ctx.Integrity = "hmac-sha256"
+			},
+			wantArgs: []string{
+				"--type", "luks2",
+				"--pbkdf", "argon2id",
+				"--pbkdf-memory", "1048576",
+				"--pbkdf-force-iterations", "4",
+				"--sector-size", "4096",
+				"--integrity", "hmac-sha256",
+			},
+		},
+		{
+			name: "luks1 with pbkdf2",
+			modify: func(ctx *LuksContext) {
+				ctx.EncryptionType = "luks1"
+				ctx.Pbkdf = "pbkdf2"
+				ctx.PbkdfIterations = "100000"
+			},
+			wantArgs: []string{"--type", "luks1", "--pbkdf", "pbkdf2", "--pbkdf-force-iterations", "100000"},
+		},
+		{
+			name: "unsupported luks type",
+			modify: func(ctx *LuksContext) {
+				ctx.EncryptionType = "plain"
+			},
+			wantErr: `unsupported luks type "plain"`,
+		},
+		{
+			name: "argon2id requires luks2",
+			modify: func(ctx *LuksContext) {
+				ctx.EncryptionType = "luks1"
+				ctx.Pbkdf = "argon2id"
+			},
+			wantErr: `pbkdf "argon2id" requires luks type luks2`,
+		},
+		{
+			name: "memory cost requires argon2",
+			modify: func(ctx *LuksContext) {
+				ctx.EncryptionType = "luks2"
+				ctx.Pbkdf = "pbkdf2"
+				ctx.PbkdfMemory = "65536"
+			},
+			wantErr: "pbkdf memory cost requires pbkdf argon2i or argon2id",
+		},
+		{
+			name: "memory cost out of range",
+			modify: func(ctx *LuksContext) {
+				ctx.EncryptionType = "luks2"
+				ctx.Pbkdf = "argon2id"
+				ctx.PbkdfMemory = "8388608"
+			},
+			wantErr: `pbkdf memory cost "8388608" must be between 32 and 4194304 KiB`,
+		},
+		{
+			name: "too few pbkdf2 iterations",
+			modify: func(ctx *LuksContext) {
+				ctx.PbkdfIterations = "10"
+			},
+			wantErr: `pbkdf iterations "10" must be at least 1000`,
+		},
+		{
+			name: "invalid sector size",
+			modify: func(ctx *LuksContext) {
+				ctx.EncryptionType = "luks2"
+				ctx.SectorSize = "8192"
+			},
+			wantErr: `sector size "8192" must be one of 512, 1024, 2048, 4096`,
+		},
+		{
+			name: "integrity requires luks2",
+			modify: func(ctx *LuksContext) {
+				ctx.Integrity = "hmac-sha256"
+			},
+			wantErr: "integrity requires luks type luks2",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := LuksContext{
+				EncryptionEnabled: true,
+				EncryptionKey:     "key",
+				EncryptionCipher:  "aes-xts-plain64",
+				EncryptionKeySize: "512",
+				VolumeName:        "volume",
+			}
+			if test.modify != nil {
+				test.modify(&ctx)
+			}
+
+			err := ctx.validate()
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("got error %q, want none", err)
+				}
+			} else if err == nil || err.Error() != test.wantErr {
+				t.Fatalf("got error %v, want %q", err, test.wantErr)
+			}
+
+			if test.wantErr == "" {
+				if diff := cmp.Diff(test.wantArgs, luksFormatOptions(ctx)); diff != "" {
+					t.Errorf("luksFormat options mismatch (-want +got):\n%s", diff)
+				}
+			}
+		})
+	}
+}
+
+func TestParseLuksKeyLocation(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       string
+		wantLocation string
+	}{
+		{
+			name: "luks2",
+			status: `/dev/mapper/pvc-1 is active and is in use.
+  type:    LUKS2
+  cipher:  aes-xts-plain64
+  keysize: 512 bits
+  key location: keyring
+  device:  /dev/sda
+`,
+			wantLocation: "keyring",
+		},
+		{
+			name: "luks1",
+			status: `/dev/mapper/pvc-1 is active and is in use.
+  type:    LUKS1
+  key location: dm-crypt
+`,
+			wantLocation: "dm-crypt",
+		},
+		{
+			name: "key location not reported",
+			status: `/dev/mapper/pvc-1 is active.
+  type:    LUKS1
+`,
+			wantLocation: "dm-crypt",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if location := parseLuksKeyLocation(test.status); location != test.wantLocation {
+				t.Errorf("got key location %q, want %q", location, test.wantLocation)
+			}
+		})
+	}
+}
+
+// fakeCryptsetup installs a cryptsetup script reporting the given key location
+// in front of $PATH. If the key location is empty, the script reports that no
+// mapping is active. The script records the arguments of each call other than
+// status in the returned file.
+func fakeCryptsetup(t *testing.T, keyLocation string) string {
+	dir, err := ioutil.TempDir("", "cryptsetup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	argsLog := filepath.Join(dir, "args.log")
+	statusCmd := `printf '/dev/mapper/%s is active and is in use.\n  type:    LUKS2\n  key location: ` + keyLocation + `\n' "$2"`
+	if keyLocation == "" {
+		statusCmd = `printf '/dev/mapper/%s is inactive.\n' "$2"; exit 4`
+	}
+	script := `#!/bin/sh
+case "$1" in
+status)
+	` + statusCmd + `
+	;;
+*)
+	echo "$@" >> ` + argsLog + `
+	;;
+esac
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "cryptsetup"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	t.Cleanup(func() { os.Setenv("PATH", path) })
+
+	return argsLog
+}
+
+func TestLuksResize(t *testing.T) {
+	tests := []struct {
+		name        string
+		keyLocation string
+		keyFile     string
+		wantArgs    string
+		wantErr     error
+	}{
+		{
+			name:        "luks2 with key",
+			keyLocation: "keyring",
+			keyFile:     "/tmp/luks-key",
+			wantArgs:    "--batch-mode resize --key-file /tmp/luks-key pvc-1",
+		},
+		{
+			name:        "luks2 without key",
+			keyLocation: "keyring",
+			wantErr:     errLuksKeyRequired,
+		},
+		{
+			name:        "luks1 without key",
+			keyLocation: "dm-crypt",
+			wantArgs:    "--batch-mode resize pvc-1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resizeLog := fakeCryptsetup(t, test.keyLocation)
+
+			err := luksResize("pvc-1", test.keyFile, logrus.New().WithField("test_enabed", true))
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("got error %v, want %v", err, test.wantErr)
+			}
+
+			var args string
+			if out, err := ioutil.ReadFile(resizeLog); err == nil {
+				args = strings.TrimSpace(string(out))
+			}
+			if args != test.wantArgs {
+				t.Errorf("got resize arguments %q, want %q", args, test.wantArgs)
+			}
+		})
+	}
+}
+
+func TestLuksAddKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		ctx      LuksContext
+		wantArgs string
+	}{
+		{
+			name:     "cryptsetup defaults",
+			wantArgs: "--batch-mode luksAddKey --key-file /tmp/old-key pvc-1 /tmp/new-key",
+		},
+		{
+			name: "key derivation settings",
+			ctx: LuksContext{
+				EncryptionType: "luks2",
+				Pbkdf:          "argon2id",
+				PbkdfMemory:    "65536",
+				SectorSize:     "4096",
+				Integrity:      "hmac-sha256",
+			},
+			wantArgs: "--batch-mode luksAddKey --key-file /tmp/old-key --type luks2 --pbkdf argon2id --pbkdf-memory 65536 pvc-1 /tmp/new-key",
+		},
+		{
+			name: "pbkdf2 iterations",
+			ctx: LuksContext{
+				Pbkdf:           "pbkdf2",
+				PbkdfIterations: "100000",
+			},
+			wantArgs: "--batch-mode luksAddKey --key-file /tmp/old-key --pbkdf pbkdf2 --pbkdf-force-iterations 100000 pvc-1 /tmp/new-key",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			argsLog := fakeCryptsetup(t, "keyring")
+
+			err := luksAddKey("pvc-1", "/tmp/old-key", "/tmp/new-key", test.ctx, logrus.New().WithField("test_enabed", true))
+			if err != nil {
+				t.Fatalf("got error: %s", err)
+			}
+
+			out, err := ioutil.ReadFile(argsLog)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if args := strings.TrimSpace(string(out)); args != test.wantArgs {
+				t.Errorf("got luksAddKey arguments %q, want %q", args, test.wantArgs)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeValidatesLuksParameters(t *testing.T) {
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		wantCode   codes.Code
+	}{
+		{
+			name: "unencrypted",
+			parameters: map[string]string{
+				LuksPbkdfAttribute: "argon2id",
+			},
+		},
+		{
+			name: "valid settings without key",
+			parameters: map[string]string{
+				LuksEncryptedAttribute: "true",
+				LuksCipherAttribute:    "aes-xts-plain64",
+				LuksKeySizeAttribute:   "512",
+				LuksTypeAttribute:      "luks2",
+				LuksPbkdfAttribute:     "argon2id",
+			},
+		},
+		{
+			name: "missing cipher",
+			parameters: map[string]string{
+				LuksEncryptedAttribute: "true",
+				LuksKeySizeAttribute:   "512",
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "argon2 with luks1",
+			parameters: map[string]string{
+				LuksEncryptedAttribute: "true",
+				LuksCipherAttribute:    "aes-xts-plain64",
+				LuksKeySizeAttribute:   "512",
+				LuksTypeAttribute:      "luks1",
+				LuksPbkdfAttribute:     "argon2id",
+			},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := &Driver{
+				region: "nyc3",
+				storage: &fakeStorageDriver{
+					volumes: map[string]*godo.Volume{},
+				},
+				account: &fakeAccountDriver{},
+				log:     logrus.New().WithField("test_enabed", true),
+			}
+
+			_, err := d.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name:       "pvc-1",
+				Parameters: test.parameters,
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+			})
+			if code := status.Code(err); code != test.wantCode {
+				t.Fatalf("got error %v, want code %s", err, test.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// DescriptionAttribute is the template the description of created volumes
+	// and snapshots is rendered from. Defaults to createdByDO.
+	DescriptionAttribute = DefaultDriverName + "/description"
+
+	// TagsAttribute is a comma-separated list of templates that additional
+	// tags of created volumes and snapshots are rendered from.
+	TagsAttribute = DefaultDriverName + "/tags"
+
+	// The keys below are passed as parameters by the external-provisioner
+	// and the external-snapshotter if they run with --extra-create-metadata.
+	pvcNameKey                   = "csi.storage.k8s.io/pvc/name"
+	pvcNamespaceKey              = "csi.storage.k8s.io/pvc/namespace"
+	pvNameKey                    = "csi.storage.k8s.io/pv/name"
+	volumeSnapshotNameKey        = "csi.storage.k8s.io/volumesnapshot/name"
+	volumeSnapshotNamespaceKey   = "csi.storage.k8s.io/volumesnapshot/namespace"
+	volumeSnapshotContentNameKey = "csi.storage.k8s.io/volumesnapshotcontent/name"
+
+	// maxTagLength is the maximum length of a DigitalOcean tag.
+	maxTagLength = 255
+)
+
+var (
+	// volumeMetadataKeys maps the placeholders available to the templates of
+	// volumes to the parameters they are filled from.
+	volumeMetadataKeys = map[string]string{
+		"pvc.name":      pvcNameKey,
+		"pvc.namespace": pvcNamespaceKey,
+		"pv.name":       pvNameKey,
+	}
+
+	// snapshotMetadataKeys maps the placeholders available to the templates
+	// of snapshots to the parameters they are filled from.
+	snapshotMetadataKeys = map[string]string{
+		"volumesnapshot.name":        volumeSnapshotNameKey,
+		"volumesnapshot.namespace":   volumeSnapshotNamespaceKey,
+		"volumesnapshotcontent.name": volumeSnapshotContentNameKey,
+	}
+
+	// templatePlaceholder matches placeholders such as ${pvc.name}, following
+	// the syntax of the secret templates of the external-provisioner.
+	templatePlaceholder = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+	// invalidTagChars matches characters not allowed in DigitalOcean tags.
+	invalidTagChars = regexp.MustCompile(`[^a-zA-Z0-9:_-]`)
+)
+
+// renderMetadata renders the description and the tags of a resource from the
+// templates in the given parameters. Placeholders are filled from the
+// parameters as mapped by keys. The description falls back to createdByDO if
+// no template is set.
+func renderMetadata(params map[string]string, keys map[string]string) (string, []string, error) {
+	description := createdByDO
+	if tmpl, ok := params[DescriptionAttribute]; ok {
+		var err error
+		description, err = renderTemplate(tmpl, params, keys)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid %s: %s", DescriptionAttribute, err)
+		}
+	}
+
+	var tags []string
+	for _, tmpl := range strings.Split(params[TagsAttribute], ",") {
+		tmpl = strings.TrimSpace(tmpl)
+		if tmpl == "" {
+			continue
+		}
+
+		tag, err := renderTemplate(tmpl, params, keys)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid %s: %s", TagsAttribute, err)
+		}
+
+		tag = invalidTagChars.ReplaceAllString(tag, "_")
+		if len(tag) > maxTagLength {
+			return "", nil, fmt.Errorf("invalid %s: tag %q is longer than %d characters", TagsAttribute, tag, maxTagLength)
+		}
+		tags = appendTag(tags, tag)
+	}
+
+	return description, tags, nil
+}
+
+// renderTemplate replaces the placeholders in the given template with the
+// parameters they are mapped to by keys. It fails for unknown placeholders and
+// for placeholders whose parameter is not set.
+func renderTemplate(tmpl string, params map[string]string, keys map[string]string) (string, error) {
+	var err error
+	rendered := templatePlaceholder.ReplaceAllStringFunc(tmpl, func(placeholder string) string {
+		name := templatePlaceholder.FindStringSubmatch(placeholder)[1]
+		key, ok := keys[name]
+		if !ok {
+			if err == nil {
+				err = fmt.Errorf("unknown placeholder %s", placeholder)
+			}
+			return ""
+		}
+
+		value := params[key]
+		if value == "" {
+			if err == nil {
+				err = fmt.Errorf("placeholder %s requires parameter %s, which is only passed if the sidecar runs with --extra-create-metadata", placeholder, key)
+			}
+			return ""
+		}
+		return value
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return rendered, nil
+}
+
+// appendTag appends the given tag unless it is empty or already present.
+func appendTag(tags []string, tag string) []string {
+	if tag == "" {
+		return tags
+	}
+	for _, t := range tags {
+		if t == tag {
+			return tags
+		}
+	}
+	return append(tags, tag)
+}
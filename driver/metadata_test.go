@@ -0,0 +1,225 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/digitalocean/godo"
+	"github.com/google/go-cmp/cmp"
+	"github.com/sirupsen/logrus"
+)
+
+func TestRenderMetadata(t *testing.T) {
+	volumeParams := map[string]string{
+		pvcNameKey:      "data-postgres-0",
+		pvcNamespaceKey: "db",
+		pvNameKey:       "pvc-0123",
+	}
+
+	tests := []struct {
+		name            string
+		params          map[string]string
+		keys            map[string]string
+		wantDescription string
+		wantTags        []string
+		wantErr         string
+	}{
+		{
+			name:            "no templates",
+			params:          volumeParams,
+			keys:            volumeMetadataKeys,
+			wantDescription: createdByDO,
+		},
+		{
+			name: "volume templates",
+			params: withParams(volumeParams, map[string]string{
+				DescriptionAttribute: "${pvc.namespace}/${pvc.name} (${pv.name})",
+				TagsAttribute:        "k8s:namespace:${pvc.namespace}, k8s:pvc:${pvc.name},,team-db",
+			}),
+			keys:            volumeMetadataKeys,
+			wantDescription: "db/data-postgres-0 (pvc-0123)",
+			wantTags:        []string{"k8s:namespace:db", "k8s:pvc:data-postgres-0", "team-db"},
+		},
+		{
+			name: "snapshot templates",
+			params: map[string]string{
+				volumeSnapshotNameKey:        "nightly",
+				volumeSnapshotNamespaceKey:   "db",
+				volumeSnapshotContentNameKey: "snapcontent-0123",
+				DescriptionAttribute:         "${volumesnapshot.namespace}/${volumesnapshot.name}",
+				TagsAttribute:                "${volumesnapshotcontent.name}",
+			},
+			keys:            snapshotMetadataKeys,
+			wantDescription: "db/nightly",
+			wantTags:        []string{"snapcontent-0123"},
+		},
+		{
+			name: "invalid tag characters replaced",
+			params: withParams(volumeParams, map[string]string{
+				pvcNameKey:    "data.postgres.0",
+				TagsAttribute: "pvc/${pvc.name}",
+			}),
+			keys:            volumeMetadataKeys,
+			wantDescription: createdByDO,
+			wantTags:        []string{"pvc_data_postgres_0"},
+		},
+		{
+			name: "duplicate tags dropped",
+			params: withParams(volumeParams, map[string]string{
+				TagsAttribute: "${pvc.namespace},db",
+			}),
+			keys:            volumeMetadataKeys,
+			wantDescription: createdByDO,
+			wantTags:        []string{"db"},
+		},
+		{
+			name: "empty description",
+			params: withParams(volumeParams, map[string]string{
+				DescriptionAttribute: "",
+			}),
+			keys: volumeMetadataKeys,
+		},
+		{
+			name: "unknown placeholder",
+			params: withParams(volumeParams, map[string]string{
+				DescriptionAttribute: "${volumesnapshot.name}",
+			}),
+			keys:    volumeMetadataKeys,
+			wantErr: "unknown placeholder ${volumesnapshot.name}",
+		},
+		{
+			name: "missing metadata",
+			params: map[string]string{
+				TagsAttribute: "${pvc.name}",
+			},
+			keys:    volumeMetadataKeys,
+			wantErr: "--extra-create-metadata",
+		},
+		{
+			name: "tag too long",
+			params: withParams(volumeParams, map[string]string{
+				TagsAttribute: strings.Repeat("a", maxTagLength+1),
+			}),
+			keys:    volumeMetadataKeys,
+			wantErr: "longer than",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			description, tags, err := renderMetadata(test.params, test.keys)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want error containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error: %s", err)
+			}
+
+			if description != test.wantDescription {
+				t.Errorf("got description %q, want %q", description, test.wantDescription)
+			}
+			if diff := cmp.Diff(test.wantTags, tags); diff != "" {
+				t.Errorf("tags mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCreateWithMetadata(t *testing.T) {
+	storage := &fakeStorageDriver{
+		volumes:   map[string]*godo.Volume{},
+		snapshots: map[string]*godo.Snapshot{},
+	}
+	d := &Driver{
+		region:  "nyc3",
+		doTag:   "k8s:cluster-id",
+		storage: storage,
+		storageActions: &fakeStorageActionsDriver{
+			volumes: storage.volumes,
+		},
+		account: &fakeAccountDriver{},
+		log:     logrus.New().WithField("test_enabed", true),
+	}
+
+	volResp, err := d.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name: "pvc-0123",
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+				},
+			},
+		},
+		Parameters: map[string]string{
+			pvcNameKey:           "data-postgres-0",
+			pvcNamespaceKey:      "db",
+			pvNameKey:            "pvc-0123",
+			DescriptionAttribute: "PVC ${pvc.namespace}/${pvc.name}",
+			TagsAttribute:        "k8s:namespace:${pvc.namespace}",
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create volume: %s", err)
+	}
+
+	vol := storage.volumes[volResp.Volume.VolumeId]
+	if want := "PVC db/data-postgres-0"; vol.Description != want {
+		t.Errorf("got volume description %q, want %q", vol.Description, want)
+	}
+	if diff := cmp.Diff([]string{"k8s:namespace:db", "k8s:cluster-id"}, vol.Tags); diff != "" {
+		t.Errorf("volume tags mismatch (-want +got):\n%s", diff)
+	}
+
+	snapResp, err := d.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{
+		Name:           "snapshot-0123",
+		SourceVolumeId: vol.ID,
+		Parameters: map[string]string{
+			volumeSnapshotNameKey:      "nightly",
+			volumeSnapshotNamespaceKey: "db",
+			TagsAttribute:              "k8s:volumesnapshot:${volumesnapshot.name}",
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create snapshot: %s", err)
+	}
+
+	snap := storage.snapshots[snapResp.Snapshot.SnapshotId]
+	if diff := cmp.Diff([]string{"k8s:volumesnapshot:nightly", "k8s:cluster-id"}, snap.Tags); diff != "" {
+		t.Errorf("snapshot tags mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func withParams(params map[string]string, overrides map[string]string) map[string]string {
+	merged := map[string]string{}
+	for k, v := range params {
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "dobs_csi"
+
+var (
+	// rpcDurationBuckets covers everything from quick node RPCs up to attach
+	// operations that wait for the full waitActionTimeout.
+	rpcDurationBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+	// apiDurationBuckets covers the latency of single DigitalOcean API calls.
+	apiDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+)
+
+// metrics holds the Prometheus metrics exposed by the driver on the
+// `/metrics` endpoint of the debug HTTP server. All methods are safe to call
+// on a nil receiver so that drivers created without metrics (e.g., in tests)
+// do not need to care.
+type metrics struct {
+	registry *prometheus.Registry
+	handler  http.Handler
+
+	rpcDuration        *prometheus.HistogramVec
+	apiRequests        *prometheus.CounterVec
+	apiDuration        *prometheus.HistogramVec
+	waitActionDuration *prometheus.HistogramVec
+	volumes            prometheus.Gauge
+	volumeLimit        prometheus.Gauge
+}
+
+// newMetrics creates the driver metrics on a dedicated registry, which also
+// carries the standard process and Go runtime collectors. A dedicated registry
+// keeps multiple drivers in the same process (e.g., in tests) from clashing.
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		rpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "rpc_duration_seconds",
+			Help:      "Duration of CSI RPCs by method and gRPC status code.",
+			Buckets:   rpcDurationBuckets,
+		}, []string{"method", "code"}),
+		apiRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "api_requests_total",
+			Help:      "Number of DigitalOcean API requests by HTTP method, path and status code.",
+		}, []string{"method", "path", "code"}),
+		apiDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "api_request_duration_seconds",
+			Help:      "Duration of DigitalOcean API requests by HTTP method and path.",
+			Buckets:   apiDurationBuckets,
+		}, []string{"method", "path"}),
+		waitActionDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "wait_action_duration_seconds",
+			Help:      "Duration of waiting for storage actions to complete by result.",
+			Buckets:   rpcDurationBuckets,
+		}, []string{"result"}),
+		volumes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "volumes",
+			Help:      "Number of volumes in the account as of the last volume limit check.",
+		}),
+		volumeLimit: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "volume_limit",
+			Help:      "Volume limit of the account as of the last volume limit check. Zero means unlimited.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		prometheus.NewGoCollector(),
+		m.rpcDuration,
+		m.apiRequests,
+		m.apiDuration,
+		m.waitActionDuration,
+		m.volumes,
+		m.volumeLimit,
+	)
+	m.handler = promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+
+	return m
+}
+
+func (m *metrics) observeRPC(method, code string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.rpcDuration.WithLabelValues(method, code).Observe(d.Seconds())
+}
+
+func (m *metrics) observeAPIRequest(method, path, code string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.apiRequests.WithLabelValues(method, path, code).Inc()
+	m.apiDuration.WithLabelValues(method, path).Observe(d.Seconds())
+}
+
+func (m *metrics) observeWaitAction(result string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.waitActionDuration.WithLabelValues(result).Observe(d.Seconds())
+}
+
+func (m *metrics) setVolumeLimit(limit, numVolumes int) {
+	if m == nil {
+		return
+	}
+	m.volumeLimit.Set(float64(limit))
+	m.volumes.Set(float64(numVolumes))
+}
+
+// ServeHTTP renders all metrics of the registry through promhttp.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	m.handler.ServeHTTP(w, r)
+}
+
+// instrumentedTransport records metrics for every request sent to the
+// DigitalOcean API.
+type instrumentedTransport struct {
+	base    http.RoundTripper
+	metrics *metrics
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	t.metrics.observeAPIRequest(req.Method, normalizeAPIPath(req.URL.Path), code, time.Since(start))
+
+	return resp, err
+}
+
+// normalizeAPIPath replaces resource IDs in the given API path with a
+// placeholder to keep the cardinality of the path label bounded.
+func normalizeAPIPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if isResourceID(segment) {
+			segments[i] = ":id"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func isResourceID(segment string) bool {
+	if segment == "" {
+		return false
+	}
+
+	// droplet and action IDs are numeric
+	if _, err := strconv.Atoi(segment); err == nil {
+		return true
+	}
+
+	// volume and snapshot IDs are UUIDs
+	return len(segment) == 36 && strings.Count(segment, "-") == 4
+}
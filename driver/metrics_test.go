@@ -0,0 +1,80 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	m := newMetrics()
+	m.observeRPC("/csi.v1.Controller/ControllerPublishVolume", "OK", 300*time.Millisecond)
+	m.observeRPC("/csi.v1.Controller/ControllerPublishVolume", "Aborted", 20*time.Millisecond)
+	m.observeAPIRequest("POST", "/v2/volumes/:id/actions", "202", 70*time.Millisecond)
+	m.observeWaitAction("completed", 3*time.Second)
+	m.setVolumeLimit(100, 42)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	got := rec.Body.String()
+
+	for _, want := range []string{
+		"# TYPE dobs_csi_rpc_duration_seconds histogram",
+		`dobs_csi_rpc_duration_seconds_bucket{code="OK",method="/csi.v1.Controller/ControllerPublishVolume",le="0.25"} 0`,
+		`dobs_csi_rpc_duration_seconds_bucket{code="OK",method="/csi.v1.Controller/ControllerPublishVolume",le="0.5"} 1`,
+		`dobs_csi_rpc_duration_seconds_bucket{code="Aborted",method="/csi.v1.Controller/ControllerPublishVolume",le="+Inf"} 1`,
+		`dobs_csi_rpc_duration_seconds_count{code="OK",method="/csi.v1.Controller/ControllerPublishVolume"} 1`,
+		`dobs_csi_api_requests_total{code="202",method="POST",path="/v2/volumes/:id/actions"} 1`,
+		`dobs_csi_wait_action_duration_seconds_sum{result="completed"} 3`,
+		"dobs_csi_volumes 42",
+		"dobs_csi_volume_limit 100",
+		"# TYPE go_goroutines gauge",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("want metrics output to contain %q, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestNormalizeAPIPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{
+			path: "/v2/volumes",
+			want: "/v2/volumes",
+		},
+		{
+			path: "/v2/volumes/506f78a4-e098-11e5-ad9f-000f53306ae1/actions/72531856",
+			want: "/v2/volumes/:id/actions/:id",
+		},
+		{
+			path: "/v2/droplets/3164444",
+			want: "/v2/droplets/:id",
+		},
+	}
+
+	for _, test := range tests {
+		if got := normalizeAPIPath(test.path); got != test.want {
+			t.Errorf("got normalized path %q for %q, want %q", got, test.path, test.want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MkfsOptionsAttribute is used to pass additional mkfs options from the
+	// StorageClass parameters to `NodeStageVolume`. Options are separated by
+	// whitespace and validated against a per-filesystem allow-list, e.g.
+	// "-m 0 -E lazy_itable_init=0" for ext4 or "-m reflink=1" for xfs.
+	MkfsOptionsAttribute = DefaultDriverName + "/mkfs-options"
+
+	// defaultFsType is the filesystem used when the volume capability does
+	// not specify one.
+	defaultFsType = "ext4"
+)
+
+// valueValidator validates the value of a mkfs option or sub-option. A nil
+// valueValidator means that the option does not take a value.
+type valueValidator func(value string) error
+
+var (
+	extMkfsOptions = map[string]valueValidator{
+		// block size
+		"-b": oneOf("1024", "2048", "4096"),
+		// bytes-per-inode ratio
+		"-i": intRange(1024, 67108864),
+		// inode size
+		"-I": oneOf("128", "256", "512", "1024"),
+		// number of inodes
+		"-N": intRange(1, 1<<32-1),
+		// percentage of reserved blocks
+		"-m": floatRange(0, 50),
+		// usage type from mke2fs.conf
+		"-T": oneOf("default", "small", "floppy", "big", "huge", "news", "largefile", "largefile4"),
+		// extended options
+		"-E": subOptions(map[string]valueValidator{
+			"lazy_itable_init":  oneOf("0", "1"),
+			"lazy_journal_init": oneOf("0", "1"),
+			"stride":            intRange(1, 1<<32-1),
+			"stripe_width":      intRange(1, 1<<32-1),
+			"stripe-width":      intRange(1, 1<<32-1),
+			"discard":           nil,
+			"nodiscard":         nil,
+		}),
+	}
+
+	// mkfsOptionsAllowList holds the mkfs options that may be set through
+	// MkfsOptionsAttribute for each supported filesystem.
+	mkfsOptionsAllowList = map[string]map[string]valueValidator{
+		"ext3": extMkfsOptions,
+		"ext4": extMkfsOptions,
+		"xfs": {
+			"-b": subOptions(map[string]valueValidator{
+				"size": oneOf("1024", "2048", "4096"),
+			}),
+			"-i": subOptions(map[string]valueValidator{
+				"size":   oneOf("256", "512", "1024", "2048"),
+				"maxpct": intRange(0, 100),
+			}),
+			"-m": subOptions(map[string]valueValidator{
+				"reflink": oneOf("0", "1"),
+				"crc":     oneOf("0", "1"),
+				"finobt":  oneOf("0", "1"),
+			}),
+			"-d": subOptions(map[string]valueValidator{
+				"agcount": intRange(1, 1<<32-1),
+				"su":      nonEmpty,
+				"sw":      intRange(1, 1<<32-1),
+			}),
+			// do not discard blocks at mkfs time
+			"-K": nil,
+		},
+	}
+)
+
+// parseMkfsOptions splits the given mkfs options and validates them against
+// the allow-list of the given filesystem.
+func parseMkfsOptions(fsType, options string) ([]string, error) {
+	fields := strings.Fields(options)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	allowed, ok := mkfsOptionsAllowList[fsType]
+	if !ok {
+		return nil, fmt.Errorf("mkfs options are not supported for filesystem %q", fsType)
+	}
+
+	var args []string
+	for i := 0; i < len(fields); i++ {
+		option := fields[i]
+		validate, ok := allowed[option]
+		if !ok {
+			return nil, fmt.Errorf("mkfs option %q is not supported for filesystem %q", option, fsType)
+		}
+
+		args = append(args, option)
+		if validate == nil {
+			continue
+		}
+
+		if i+1 == len(fields) {
+			return nil, fmt.Errorf("mkfs option %q requires a value", option)
+		}
+		i++
+		if err := validate(fields[i]); err != nil {
+			return nil, fmt.Errorf("invalid value for mkfs option %q: %s", option, err)
+		}
+		args = append(args, fields[i])
+	}
+
+	return args, nil
+}
+
+func oneOf(values ...string) valueValidator {
+	return func(value string) error {
+		for _, v := range values {
+			if value == v {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q must be one of %s", value, strings.Join(values, ", "))
+	}
+}
+
+func intRange(min, max int64) valueValidator {
+	return func(value string) error {
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || i < min || i > max {
+			return fmt.Errorf("%q must be an integer between %d and %d", value, min, max)
+		}
+		return nil
+	}
+}
+
+func floatRange(min, max float64) valueValidator {
+	return func(value string) error {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f < min || f > max {
+			return fmt.Errorf("%q must be a number between %g and %g", value, min, max)
+		}
+		return nil
+	}
+}
+
+func nonEmpty(value string) error {
+	if value == "" {
+		return fmt.Errorf("value must not be empty")
+	}
+	return nil
+}
+
+// subOptions validates a comma-separated list of sub-options such as
+// "lazy_itable_init=0,discard".
+func subOptions(allowed map[string]valueValidator) valueValidator {
+	return func(value string) error {
+		for _, opt := range strings.Split(value, ",") {
+			key, val, hasValue := opt, "", false
+			if i := strings.Index(opt, "="); i >= 0 {
+				key, val, hasValue = opt[:i], opt[i+1:], true
+			}
+
+			validate, ok := allowed[key]
+			if !ok {
+				return fmt.Errorf("sub-option %q is not supported", key)
+			}
+			if validate == nil {
+				if hasValue {
+					return fmt.Errorf("sub-option %q does not take a value", key)
+				}
+				continue
+			}
+			if !hasValue {
+				return fmt.Errorf("sub-option %q requires a value", key)
+			}
+			if err := validate(val); err != nil {
+				return fmt.Errorf("sub-option %q: %s", key, err)
+			}
+		}
+		return nil
+	}
+}
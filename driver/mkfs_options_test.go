@@ -0,0 +1,117 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseMkfsOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		fsType   string
+		options  string
+		wantArgs []string
+		wantErr  bool
+	}{
+		{
+			name:   "no options",
+			fsType: "ext4",
+		},
+		{
+			name:     "ext4 tuning",
+			fsType:   "ext4",
+			options:  "-m 0 -i 65536 -b 4096 -E lazy_itable_init=0,lazy_journal_init=0,discard",
+			wantArgs: []string{"-m", "0", "-i", "65536", "-b", "4096", "-E", "lazy_itable_init=0,lazy_journal_init=0,discard"},
+		},
+		{
+			name:     "ext4 fractional reserved blocks",
+			fsType:   "ext4",
+			options:  " -m  0.5 ",
+			wantArgs: []string{"-m", "0.5"},
+		},
+		{
+			name:     "xfs reflink",
+			fsType:   "xfs",
+			options:  "-m reflink=1,crc=1 -i size=512 -K",
+			wantArgs: []string{"-m", "reflink=1,crc=1", "-i", "size=512", "-K"},
+		},
+		{
+			name:    "option not allowed",
+			fsType:  "ext4",
+			options: "-O ^has_journal",
+			wantErr: true,
+		},
+		{
+			name:    "ext4 option for xfs",
+			fsType:  "xfs",
+			options: "-E lazy_itable_init=0",
+			wantErr: true,
+		},
+		{
+			name:    "reserved blocks out of range",
+			fsType:  "ext4",
+			options: "-m 60",
+			wantErr: true,
+		},
+		{
+			name:    "missing value",
+			fsType:  "ext4",
+			options: "-m",
+			wantErr: true,
+		},
+		{
+			name:    "unknown sub-option",
+			fsType:  "ext4",
+			options: "-E root_owner=0:0",
+			wantErr: true,
+		},
+		{
+			name:    "sub-option without required value",
+			fsType:  "xfs",
+			options: "-m reflink",
+			wantErr: true,
+		},
+		{
+			name:    "sub-option with unexpected value",
+			fsType:  "ext4",
+			options: "-E discard=1",
+			wantErr: true,
+		},
+		{
+			name:    "unsupported filesystem",
+			fsType:  "btrfs",
+			options: "-m 0",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args, err := parseMkfsOptions(test.fsType, test.options)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error: %t", err, test.wantErr)
+			}
+
+			if diff := cmp.Diff(test.wantArgs, args); diff != "" {
+				t.Errorf("args mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -17,15 +17,18 @@ limitations under the License.
 package driver
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sys/unix"
@@ -48,7 +51,36 @@ type volumeStatistics struct {
 	availableInodes, totalInodes, usedInodes int64
 }
 
+var (
+	// scsiHostScanGlob matches the files that trigger a rescan of the SCSI
+	// hosts
+	scsiHostScanGlob = "/sys/class/scsi_host/host*/scan"
+
+	// sysClassBlockPath is the sysfs directory listing all block devices
+	sysClassBlockPath = "/sys/class/block"
+
+	// sysDevBlockPath is the sysfs directory listing all block devices by
+	// their device numbers
+	sysDevBlockPath = "/sys/dev/block"
+
+	// mountInfoPath is the file listing the mounts visible to the plugin
+	mountInfoPath = "/proc/self/mountinfo"
+)
+
+type volumeCondition struct {
+	abnormal bool
+	message  string
+}
+
 const (
+	// devicePollInterval is the interval in which WaitForDevice checks whether
+	// the device has appeared.
+	devicePollInterval = 500 * time.Millisecond
+
+	// deviceRetriggerInterval is the interval in which WaitForDevice asks udev
+	// to pick up the device again while it is missing.
+	deviceRetriggerInterval = 5 * time.Second
+
 	// blkidExitStatusNoIdentifiers defines the exit code returned from blkid indicating that no devices have been found. See http://www.polarhome.com/service/man/?qf=blkid&tf=2&of=Alpinelinux for details.
 	blkidExitStatusNoIdentifiers = 2
 )
@@ -57,19 +89,58 @@ const (
 // TODO(timoreimann): find a more suitable name since the interface encompasses
 // more than just mounting functionality by now.
 type Mounter interface {
-	// Format formats the source with the given filesystem type
-	Format(source, fsType string, luksContext LuksContext) error
+	// WaitForDevice blocks until the given device path exists or the context
+	// is done. While the device is missing, the udev events of the device are
+	// replayed in case an event got lost, and the SCSI hosts are rescanned
+	// once if the device is unknown to the kernel.
+	WaitForDevice(ctx context.Context, devicePath string) error
+
+	// GetDeviceIdentifiers returns the SCSI identifiers (i.e. the unit serial
+	// number and the device identification) the given device reports through
+	// sysfs. No identifiers are returned if the device does not report any.
+	GetDeviceIdentifiers(devicePath string) ([]string, error)
+
+	// Format formats the source with the given filesystem type and
+	// additional mkfs options
+	Format(source, fsType string, mkfsOptions []string, luksContext LuksContext) error
 
 	// Mount mounts source to target with the given fstype and options.
 	Mount(source, target, fsType string, luksContext LuksContext, options ...string) error
 
+	// SetBlockDeviceReadOnly sets or clears the read-only flag of the block
+	// device at the given path. The flag applies to the device itself, i.e.
+	// to all paths the device is bind mounted to.
+	SetBlockDeviceReadOnly(devicePath string, readOnly bool) error
+
+	// IsBlockDeviceReadOnly returns whether the read-only flag of the block
+	// device at the given path is set.
+	IsBlockDeviceReadOnly(devicePath string) (bool, error)
+
+	// GetPublishedBlockDevice returns the path of the block device that is
+	// bind mounted to the given target. An empty path is returned if the
+	// target is not a published raw block volume.
+	GetPublishedBlockDevice(target string) (string, error)
+
+	// GetBindMountTargets returns the mount points the given staging path or
+	// raw block device is bind mounted to.
+	GetBindMountTargets(source string, isDevice bool) ([]string, error)
+
 	// Unmount unmounts the given target
 	Unmount(target string, luksContext LuksContext) error
 
+	// RotateLuksKey replaces the previous luks key of the source device with
+	// the current one if the luks context carries both.
+	RotateLuksKey(source string, luksContext LuksContext) error
+
 	// IsFormatted checks whether the source device is formatted or not. It
 	// returns true if the source device is already formatted.
 	IsFormatted(source string, luksContext LuksContext) (bool, error)
 
+	// GetForeignSignatures returns descriptions of the partition tables and
+	// volume manager signatures (e.g. LVM, mdraid, bcache or ZFS) found on
+	// the source device.
+	GetForeignSignatures(source string) ([]string, error)
+
 	// IsMounted checks whether the target path is a correct mount (i.e:
 	// propagated). It returns true if it's mounted. An error is returned in
 	// case of system errors or if it's mounted incorrectly.
@@ -77,12 +148,37 @@ type Mounter interface {
 
 	GetDeviceName(mounter mount.Interface, mountPath string) (string, error)
 
+	// ResizeLuksMapping grows the luks mapping at the given device path to
+	// the size of the underlying device, using the luks key of the given
+	// context if it is set. It is a no-op if the device is not a luks
+	// mapping.
+	ResizeLuksMapping(devicePath string, luksContext LuksContext) error
+
+	// FindPartition returns the device path of the partition of the given
+	// disk that matches the selector, or an empty path if none matches.
+	FindPartition(disk string, selector partitionSelector) (string, error)
+
+	// GrowPartition grows the partition backing the given device path to the
+	// end of its disk. It is a no-op if the device is not backed by a
+	// partition.
+	GrowPartition(devicePath string) error
+
+	// CountUnmanagedVolumes returns the number of DigitalOcean volumes
+	// attached to the node that are not managed through CSI by the driver
+	// with the given name.
+	CountUnmanagedVolumes(driverName string) (int, error)
+
 	// GetStatistics returns capacity-related volume statistics for the given
 	// volume path.
 	GetStatistics(volumePath string) (volumeStatistics, error)
 
 	// IsBlockDevice checks whether the device at the path is a block device
 	IsBlockDevice(volumePath string) (bool, error)
+
+	// GetVolumeCondition inspects the device and filesystem backing the given
+	// volume path and reports whether the volume is in an abnormal state. The
+	// staging path of the volume is optional.
+	GetVolumeCondition(volumePath, stagingPath string) (volumeCondition, error)
 }
 
 // TODO(arslan): this is Linux only for now. Refactor this into a package with
@@ -99,7 +195,249 @@ func newMounter(log *logrus.Entry) *mounter {
 	}
 }
 
-func (m *mounter) Format(source, fsType string, luksContext LuksContext) error {
+func (m *mounter) WaitForDevice(ctx context.Context, devicePath string) error {
+	exists := func() (bool, error) {
+		_, err := os.Stat(devicePath)
+		if err == nil {
+			return true, nil
+		}
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	found, err := exists()
+	if err != nil || found {
+		return err
+	}
+
+	log := m.log.WithField("device_path", devicePath)
+	log.Warn("device not found, waiting for it to appear")
+
+	ticker := time.NewTicker(devicePollInterval)
+	defer ticker.Stop()
+
+	var lastRetrigger time.Time
+	for {
+		if time.Since(lastRetrigger) >= deviceRetriggerInterval {
+			m.retriggerDevice(log, devicePath, lastRetrigger.IsZero())
+			lastRetrigger = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("device %s did not appear: %s", devicePath, ctx.Err())
+		case <-ticker.C:
+		}
+
+		found, err := exists()
+		if err != nil {
+			return err
+		}
+		if found {
+			log.Info("device appeared")
+			return nil
+		}
+	}
+}
+
+// retriggerDevice replays the udev events of the device behind the given
+// by-id path so that its missing link is created. If rescan is set and the
+// kernel does not know the device yet, the SCSI hosts are rescanned first.
+// Failures are only logged since the device may still show up on its own.
+func (m *mounter) retriggerDevice(log *logrus.Entry, devicePath string, rescan bool) {
+	volumeName := strings.TrimPrefix(filepath.Base(devicePath), diskDOPrefix)
+	if _, found := findBlockDeviceBySerial(volumeName); rescan && !found {
+		rescanSCSIHosts(log)
+	}
+
+	for _, args := range [][]string{
+		udevTriggerArgs(devicePath),
+		{"settle", "--timeout=" + strconv.Itoa(int(deviceRetriggerInterval.Seconds()))},
+	} {
+		log.WithFields(logrus.Fields{
+			"cmd":  "udevadm",
+			"args": args,
+		}).Info("executing udevadm command")
+
+		out, err := exec.Command("udevadm", args...).CombinedOutput()
+		if err != nil {
+			log.WithError(err).WithField("output", string(out)).Warn("udevadm command failed")
+		}
+	}
+}
+
+// rescanSCSIHosts asks all SCSI hosts to scan for new devices.
+func rescanSCSIHosts(log *logrus.Entry) {
+	scanFiles, err := filepath.Glob(scsiHostScanGlob)
+	if err != nil {
+		log.WithError(err).Warn("failed to find SCSI hosts")
+	}
+	for _, scanFile := range scanFiles {
+		// wildcards for channel, target and LUN
+		if err := ioutil.WriteFile(scanFile, []byte("- - -"), 0200); err != nil {
+			log.WithError(err).WithField("scan_file", scanFile).Warn("failed to rescan SCSI host")
+		}
+	}
+}
+
+// udevTriggerArgs returns the arguments of the udevadm command replaying the
+// events of the device behind the given by-id path only. The device is looked
+// up in sysfs by its serial number since the link is missing; if the kernel
+// does not know it, udev is asked to match the serial it recorded instead.
+func udevTriggerArgs(devicePath string) []string {
+	args := []string{"trigger", "--action=add", "--subsystem-match=block"}
+	volumeName := strings.TrimPrefix(filepath.Base(devicePath), diskDOPrefix)
+	if name, found := findBlockDeviceBySerial(volumeName); found {
+		return append(args, "--sysname-match="+name)
+	}
+	return append(args, "--property-match=ID_SERIAL="+strings.TrimPrefix(filepath.Base(devicePath), "scsi-"))
+}
+
+// findBlockDeviceBySerial returns the name of the disk whose unit serial
+// number is the given serial.
+func findBlockDeviceBySerial(serial string) (string, bool) {
+	entries, err := ioutil.ReadDir(sysClassBlockPath)
+	if err != nil {
+		return "", false
+	}
+
+	for _, entry := range entries {
+		sysPath := filepath.Join(sysClassBlockPath, entry.Name())
+		if _, err := os.Stat(filepath.Join(sysPath, "partition")); err == nil {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(sysPath, "device", "vpd_pg80"))
+		if err != nil {
+			continue
+		}
+		for _, s := range parseUnitSerialNumberPage(data) {
+			if s == serial {
+				return entry.Name(), true
+			}
+		}
+	}
+	return "", false
+}
+
+func (m *mounter) GetDeviceIdentifiers(devicePath string) ([]string, error) {
+	return getDeviceIdentifiers(devicePath)
+}
+
+// getDeviceIdentifiers returns the SCSI identifiers of the given device from
+// the vital product data pages exposed in sysfs. Partitions are resolved to
+// the disk they belong to.
+func getDeviceIdentifiers(devicePath string) ([]string, error) {
+	sysPath, err := getSysBlockPath(devicePath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(sysPath, "partition")); err == nil {
+		sysPath = filepath.Dir(sysPath)
+	}
+
+	readers := []struct {
+		file  string
+		parse func([]byte) []string
+	}{
+		{file: "vpd_pg80", parse: parseUnitSerialNumberPage},
+		{file: "vpd_pg83", parse: parseDeviceIdentificationPage},
+		{file: "wwid", parse: func(data []byte) []string {
+			return nonEmptyStrings(strings.TrimSpace(string(data)))
+		}},
+	}
+
+	var identifiers []string
+	for _, r := range readers {
+		data, err := ioutil.ReadFile(filepath.Join(sysPath, "device", r.file))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		identifiers = append(identifiers, r.parse(data)...)
+	}
+
+	return identifiers, nil
+}
+
+// parseUnitSerialNumberPage returns the serial number from a raw SCSI unit
+// serial number VPD page (0x80).
+func parseUnitSerialNumberPage(data []byte) []string {
+	if len(data) < 4 {
+		return nil
+	}
+	length := int(data[2])<<8 | int(data[3])
+	if 4+length > len(data) {
+		length = len(data) - 4
+	}
+	return nonEmptyStrings(strings.Trim(string(data[4:4+length]), " \x00"))
+}
+
+// parseDeviceIdentificationPage returns the designators from a raw SCSI
+// device identification VPD page (0x83). Binary designators such as the WWN
+// are returned hex encoded.
+func parseDeviceIdentificationPage(data []byte) []string {
+	if len(data) < 4 {
+		return nil
+	}
+	end := 4 + (int(data[2])<<8 | int(data[3]))
+	if end > len(data) {
+		end = len(data)
+	}
+
+	var designators []string
+	for i := 4; i+4 <= end; {
+		codeSet := data[i] & 0x0f
+		length := int(data[i+3])
+		if i+4+length > end {
+			break
+		}
+		designator := data[i+4 : i+4+length]
+		i += 4 + length
+
+		switch codeSet {
+		case 1: // binary
+			designators = append(designators, fmt.Sprintf("%x", designator))
+		case 2, 3: // ASCII, UTF-8
+			designators = append(designators, nonEmptyStrings(strings.Trim(string(designator), " \x00"))...)
+		}
+	}
+	return designators
+}
+
+func nonEmptyStrings(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return []string{s}
+}
+
+// matchDeviceIdentifiers checks whether the given SCSI identifiers of a
+// device belong to the volume with the given ID, i.e. whether the serial
+// number or the WWN carries the ID.
+//
+// The volume name is deliberately not matched: the by-id link is built from
+// the serial number, so a device whose serial is merely the name of the volume
+// cannot be told apart from a stale link or a name collision.
+func matchDeviceIdentifiers(identifiers []string, volumeID string) bool {
+	normalizedID := strings.ToLower(strings.Replace(volumeID, "-", "", -1))
+	if normalizedID == "" {
+		return false
+	}
+
+	for _, identifier := range identifiers {
+		normalized := strings.ToLower(strings.Replace(identifier, "-", "", -1))
+		if strings.Contains(normalized, normalizedID) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *mounter) Format(source, fsType string, mkfsOptions []string, luksContext LuksContext) error {
 	mkfsCmd := fmt.Sprintf("mkfs.%s", fsType)
 
 	_, err := exec.LookPath(mkfsCmd)
@@ -120,10 +458,11 @@ func (m *mounter) Format(source, fsType string, luksContext LuksContext) error {
 		return errors.New("source is not specified for formatting the volume")
 	}
 
-	mkfsArgs = append(mkfsArgs, source)
 	if fsType == "ext4" || fsType == "ext3" {
-		mkfsArgs = []string{"-F", source}
+		mkfsArgs = append(mkfsArgs, "-F")
 	}
+	mkfsArgs = append(mkfsArgs, mkfsOptions...)
+	mkfsArgs = append(mkfsArgs, source)
 
 	if !luksContext.EncryptionEnabled {
 		m.log.WithFields(logrus.Fields{
@@ -221,9 +560,92 @@ func (m *mounter) Mount(source, target, fsType string, luksContext LuksContext,
 			err, mountCmd, strings.Join(mountArgs, " "), string(out))
 	}
 
+	// the kernel ignores the read-only flag when creating a bind mount, it
+	// only applies to a subsequent remount
+	if hasOption(opts, "bind") && hasOption(opts, "ro") {
+		remountArgs := []string{"-o", "remount,bind,ro", target}
+
+		m.log.WithFields(logrus.Fields{
+			"cmd":  mountCmd,
+			"args": remountArgs,
+		}).Info("executing remount command")
+
+		out, err := exec.Command(mountCmd, remountArgs...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("remounting read-only failed: %v cmd: '%s %s' output: %q",
+				err, mountCmd, strings.Join(remountArgs, " "), string(out))
+		}
+	}
+
 	return nil
 }
 
+func (m *mounter) GetBindMountTargets(source string, isDevice bool) ([]string, error) {
+	mountInfos, err := mount.ParseMountInfo(mountInfoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return findBindMountTargets(mountInfos, source, isDevice), nil
+}
+
+func hasOption(opts []string, option string) bool {
+	for _, opt := range opts {
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *mounter) SetBlockDeviceReadOnly(devicePath string, readOnly bool) error {
+	blockdevCmd := "blockdev"
+	blockdevArgs := []string{"--setrw", devicePath}
+	if readOnly {
+		blockdevArgs[0] = "--setro"
+	}
+
+	m.log.WithFields(logrus.Fields{
+		"cmd":  blockdevCmd,
+		"args": blockdevArgs,
+	}).Info("executing blockdev command")
+
+	out, err := exec.Command(blockdevCmd, blockdevArgs...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("setting read-only flag failed: %v cmd: '%s %s' output: %q",
+			err, blockdevCmd, strings.Join(blockdevArgs, " "), string(out))
+	}
+
+	return nil
+}
+
+func (m *mounter) IsBlockDeviceReadOnly(devicePath string) (bool, error) {
+	blockdevCmd := "blockdev"
+	blockdevArgs := []string{"--getro", devicePath}
+
+	out, err := exec.Command(blockdevCmd, blockdevArgs...).CombinedOutput()
+	if err != nil {
+		return false, fmt.Errorf("getting read-only flag failed: %v cmd: '%s %s' output: %q",
+			err, blockdevCmd, strings.Join(blockdevArgs, " "), string(out))
+	}
+
+	return strings.TrimSpace(string(out)) == "1", nil
+}
+
+func (m *mounter) GetPublishedBlockDevice(target string) (string, error) {
+	mountInfos, err := mount.ParseMountInfo(mountInfoPath)
+	if err != nil {
+		return "", err
+	}
+
+	for _, mi := range mountInfos {
+		if mi.MountPoint == target && mi.FsType == "devtmpfs" {
+			return filepath.Join("/dev", mountedDeviceName(mi)), nil
+		}
+	}
+	return "", nil
+}
+
 func (m *mounter) Unmount(target string, luksContext LuksContext) error {
 	if target == "" {
 		return errors.New("target is not specified for unmounting the volume")
@@ -295,6 +717,14 @@ func getMountSources(target string) ([]string, error) {
 	return strings.Split(string(out), "\n"), nil
 }
 
+func (m *mounter) RotateLuksKey(source string, luksContext LuksContext) error {
+	if !luksContext.EncryptionEnabled {
+		return nil
+	}
+
+	return luksRotateKey(source, luksContext, m.log)
+}
+
 func (m *mounter) IsFormatted(source string, luksContext LuksContext) (bool, error) {
 	if !luksContext.EncryptionEnabled {
 		return isVolumeFormatted(source, m.log)
@@ -348,6 +778,48 @@ func isVolumeFormatted(source string, log *logrus.Entry) (bool, error) {
 	return true, nil
 }
 
+// GetForeignSignatures probes the source device for partition tables and
+// volume manager signatures, including those that blkid ignores when looking
+// for filesystems.
+func (m *mounter) GetForeignSignatures(source string) ([]string, error) {
+	if source == "" {
+		return nil, errors.New("source is not specified")
+	}
+
+	blkidCmd := "blkid"
+	_, err := exec.LookPath(blkidCmd)
+	if err != nil {
+		if err == exec.ErrNotFound {
+			return nil, fmt.Errorf("%q executable not found in $PATH", blkidCmd)
+		}
+		return nil, err
+	}
+
+	blkidArgs := []string{"--probe", "--output", "export", source}
+
+	m.log.WithFields(logrus.Fields{
+		"cmd":  blkidCmd,
+		"args": blkidArgs,
+	}).Info("probing source for foreign signatures")
+
+	out, err := exec.Command(blkidCmd, blkidArgs...).Output()
+	if err != nil {
+		exitError, ok := err.(*exec.ExitError)
+		if !ok {
+			return nil, fmt.Errorf("probing signatures failed: %v cmd: %q, args: %q", err, blkidCmd, blkidArgs)
+		}
+		switch exitError.Sys().(syscall.WaitStatus).ExitStatus() {
+		case blkidExitStatusNoIdentifiers:
+			return nil, nil
+		case blkidExitStatusAmbivalent:
+			return []string{"multiple conflicting signatures"}, nil
+		}
+		return nil, fmt.Errorf("probing signatures failed: %v cmd: %q, args: %q", err, blkidCmd, blkidArgs)
+	}
+
+	return parseForeignSignatures(string(out)), nil
+}
+
 func (m *mounter) IsMounted(target string) (bool, error) {
 	if target == "" {
 		return false, errors.New("target is not specified for checking the mount")
@@ -412,6 +884,206 @@ func (m *mounter) GetDeviceName(mounter mount.Interface, mountPath string) (stri
 	return devicePath, err
 }
 
+func (m *mounter) ResizeLuksMapping(devicePath string, luksContext LuksContext) error {
+	isLuks, mappingName, err := isLuksMapping(devicePath)
+	if err != nil {
+		return err
+	}
+	if !isLuks {
+		return nil
+	}
+
+	if luksContext.EncryptionKey == "" {
+		return luksResize(mappingName, "", m.log)
+	}
+
+	filename, err := writeLuksKey(luksContext.EncryptionKey, m.log)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		e := os.Remove(filename)
+		if e != nil {
+			m.log.Errorf("cannot delete temporary file %s: %s", filename, e.Error())
+		}
+	}()
+
+	return luksResize(mappingName, filename, m.log)
+}
+
+// FindPartition returns the device path of the partition of the given disk
+// that matches the selector. An empty path is returned if no partition
+// matches.
+func (m *mounter) FindPartition(disk string, selector partitionSelector) (string, error) {
+	diskSysPath, err := getSysBlockPath(disk)
+	if err != nil {
+		return "", err
+	}
+
+	entries, err := ioutil.ReadDir(diskSysPath)
+	if err != nil {
+		return "", err
+	}
+
+	var partitions []string
+	for _, entry := range entries {
+		data, err := ioutil.ReadFile(filepath.Join(diskSysPath, entry.Name(), "partition"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				// not a partition
+				continue
+			}
+			return "", err
+		}
+
+		partition := filepath.Join("/dev", entry.Name())
+		if selector.tag == "" {
+			if strings.TrimSpace(string(data)) == strconv.Itoa(selector.number) {
+				return partition, nil
+			}
+			continue
+		}
+		partitions = append(partitions, partition)
+	}
+
+	if len(partitions) == 0 {
+		return "", nil
+	}
+
+	blkidCmd := "blkid"
+	blkidArgs := append([]string{"--match-token", selector.String(), "--output", "device"}, partitions...)
+
+	m.log.WithFields(logrus.Fields{
+		"cmd":  blkidCmd,
+		"args": blkidArgs,
+	}).Info("looking up partition")
+
+	out, err := exec.Command(blkidCmd, blkidArgs...).Output()
+	if err != nil {
+		if exitError, ok := err.(*exec.ExitError); ok && exitError.Sys().(syscall.WaitStatus).ExitStatus() == blkidExitStatusNoIdentifiers {
+			return "", nil
+		}
+		return "", fmt.Errorf("looking up partition failed: %v cmd: %q, args: %q", err, blkidCmd, blkidArgs)
+	}
+
+	matches := strings.Fields(string(out))
+	if len(matches) > 1 {
+		return "", fmt.Errorf("partition %s is ambiguous, it matches %s", selector, strings.Join(matches, ", "))
+	}
+	if len(matches) == 0 {
+		return "", nil
+	}
+	return matches[0], nil
+}
+
+// CountUnmanagedVolumes returns the number of DigitalOcean volumes attached
+// to the node that are neither mounted by the kubelet, nor recorded by the
+// kubelet as volumes of the given driver, nor dynamically provisioned.
+func (m *mounter) CountUnmanagedVolumes(driverName string) (int, error) {
+	mountInfos, err := mount.ParseMountInfo(mountInfoPath)
+	if err != nil {
+		return 0, err
+	}
+
+	volumeIDs, err := kubeletCSIVolumeIDs(filepath.Join(kubeletDir, kubeletCSIPluginDir), driverName)
+	if err != nil {
+		return 0, err
+	}
+
+	return countUnmanagedVolumes(diskIDPath, mountInfos, kubeletDir, volumeIDs)
+}
+
+// GrowPartition grows the partition backing the given device path (directly
+// or through a device mapper target such as a luks mapping) to the end of its
+// disk. It is a no-op if the device is not backed by a partition or the
+// partition cannot grow any further.
+func (m *mounter) GrowPartition(devicePath string) error {
+	disk, number, ok, err := getBackingPartition(devicePath)
+	if err != nil || !ok {
+		return err
+	}
+
+	growpartCmd := "growpart"
+	_, err = exec.LookPath(growpartCmd)
+	if err != nil {
+		if err == exec.ErrNotFound {
+			return fmt.Errorf("%q executable not found in $PATH", growpartCmd)
+		}
+		return err
+	}
+
+	growpartArgs := []string{disk, strconv.Itoa(number)}
+
+	m.log.WithFields(logrus.Fields{
+		"cmd":  growpartCmd,
+		"args": growpartArgs,
+	}).Info("growing partition")
+
+	out, err := exec.Command(growpartCmd, growpartArgs...).CombinedOutput()
+	if err != nil {
+		// growpart fails with NOCHANGE if the partition already fills the
+		// disk or is followed by another partition
+		if strings.Contains(string(out), "NOCHANGE") {
+			return nil
+		}
+		return fmt.Errorf("growing partition failed: %v cmd: '%s %s' output: %q",
+			err, growpartCmd, strings.Join(growpartArgs, " "), string(out))
+	}
+
+	return nil
+}
+
+// getBackingPartition returns the disk and number of the partition backing
+// the given device path, following device mapper targets with a single
+// underlying device. ok is false if the device is not backed by a partition.
+func getBackingPartition(devicePath string) (disk string, number int, ok bool, err error) {
+	sysPath, err := getSysBlockPath(devicePath)
+	if err != nil {
+		return "", 0, false, err
+	}
+
+	for {
+		data, err := ioutil.ReadFile(filepath.Join(sysPath, "partition"))
+		if err == nil {
+			number, err := strconv.Atoi(strings.TrimSpace(string(data)))
+			if err != nil {
+				return "", 0, false, fmt.Errorf("invalid partition number of %s: %v", sysPath, err)
+			}
+			return filepath.Join("/dev", filepath.Base(filepath.Dir(sysPath))), number, true, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", 0, false, err
+		}
+
+		slaves, err := ioutil.ReadDir(filepath.Join(sysPath, "slaves"))
+		if err != nil && !os.IsNotExist(err) {
+			return "", 0, false, err
+		}
+		if len(slaves) != 1 {
+			return "", 0, false, nil
+		}
+
+		sysPath, err = filepath.EvalSymlinks(filepath.Join(sysClassBlockPath, slaves[0].Name()))
+		if err != nil {
+			return "", 0, false, fmt.Errorf("could not find device %s in sysfs: %v", slaves[0].Name(), err)
+		}
+	}
+}
+
+// getSysBlockPath returns the sysfs directory of the given block device.
+func getSysBlockPath(devicePath string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve symlink %q: %v", devicePath, err)
+	}
+
+	sysPath, err := filepath.EvalSymlinks(filepath.Join(sysClassBlockPath, filepath.Base(resolved)))
+	if err != nil {
+		return "", fmt.Errorf("could not find device %s in sysfs: %v", resolved, err)
+	}
+	return sysPath, nil
+}
+
 func (m *mounter) GetStatistics(volumePath string) (volumeStatistics, error) {
 	isBlock, err := m.IsBlockDevice(volumePath)
 	if err != nil {
@@ -464,3 +1136,156 @@ func (m *mounter) IsBlockDevice(devicePath string) (bool, error) {
 
 	return (stat.Mode & unix.S_IFMT) == unix.S_IFBLK, nil
 }
+
+func (m *mounter) GetVolumeCondition(volumePath, stagingPath string) (volumeCondition, error) {
+	isBlock, err := m.IsBlockDevice(volumePath)
+	if err != nil {
+		return volumeCondition{}, fmt.Errorf("failed to determine if volume %s is block device: %v", volumePath, err)
+	}
+
+	if isBlock {
+		return getBlockVolumeCondition(volumePath)
+	}
+
+	mountInfos, err := mount.ParseMountInfo(mountInfoPath)
+	if err != nil {
+		return volumeCondition{}, err
+	}
+
+	mi, ok := findMountInfo(mountInfos, volumePath)
+	if !ok {
+		return volumeCondition{}, fmt.Errorf("target %q is not mounted", volumePath)
+	}
+
+	// ext4 and xfs remount the whole filesystem read-only on I/O errors, which
+	// shows up in the superblock options only. A read-only bind mount only
+	// affects the per-mount options and is therefore not mistaken for an
+	// error, whereas a volume staged read-only (or a filesystem that can only
+	// be mounted read-only) has a read-only staging mount as well.
+	if hasOption(mi.SuperOptions, "ro") && !stagedReadOnly(mountInfos, mi, stagingPath) {
+		return volumeCondition{
+			abnormal: true,
+			message:  fmt.Sprintf("filesystem on %s has been remounted read-only, possibly due to I/O errors", mi.Source),
+		}, nil
+	}
+
+	source := mi.Source
+
+	if strings.HasPrefix(source, "/dev/mapper/") {
+		mappingName := strings.TrimPrefix(source, "/dev/mapper/")
+		active, err := isLuksMappingActive(mappingName)
+		if err != nil {
+			return volumeCondition{}, err
+		}
+		if !active {
+			return volumeCondition{
+				abnormal: true,
+				message:  fmt.Sprintf("luks mapping %s is closed while still being mounted", mappingName),
+			}, nil
+		}
+
+		// luks mappings are named after the volume they encrypt
+		if _, err := os.Stat(getDeviceByIDPath(mappingName)); os.IsNotExist(err) {
+			return volumeCondition{
+				abnormal: true,
+				message:  fmt.Sprintf("device %s backing luks mapping %s has disappeared", getDeviceByIDPath(mappingName), mappingName),
+			}, nil
+		}
+
+		return volumeCondition{message: "volume is healthy"}, nil
+	}
+
+	return getDeviceCondition(source)
+}
+
+// getBlockVolumeCondition checks that the device bind mounted to the given
+// path of a raw block volume still exists.
+func getBlockVolumeCondition(volumePath string) (volumeCondition, error) {
+	var stat unix.Stat_t
+	if err := unix.Stat(volumePath, &stat); err != nil {
+		return volumeCondition{}, err
+	}
+
+	sysPath := filepath.Join(sysDevBlockPath, fmt.Sprintf("%d:%d", unix.Major(uint64(stat.Rdev)), unix.Minor(uint64(stat.Rdev))))
+	resolved, err := filepath.EvalSymlinks(sysPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return volumeCondition{
+				abnormal: true,
+				message:  fmt.Sprintf("block device bind mounted to %s has disappeared", volumePath),
+			}, nil
+		}
+		return volumeCondition{}, fmt.Errorf("could not resolve symlink %q: %v", sysPath, err)
+	}
+
+	return getDeviceCondition(filepath.Join("/dev", filepath.Base(resolved)))
+}
+
+// getDeviceCondition checks that the given device still exists and is linked
+// to a DigitalOcean volume under /dev/disk/by-id. Partitions of volumes are
+// linked with a "-part<number>" suffix and match the same prefix.
+func getDeviceCondition(device string) (volumeCondition, error) {
+	resolved, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return volumeCondition{
+				abnormal: true,
+				message:  fmt.Sprintf("device %s has disappeared", device),
+			}, nil
+		}
+		return volumeCondition{}, fmt.Errorf("could not resolve symlink %q: %v", device, err)
+	}
+
+	links, err := filepath.Glob(filepath.Join(diskIDPath, diskDOPrefix+"*"))
+	if err != nil {
+		return volumeCondition{}, err
+	}
+
+	for _, link := range links {
+		target, err := filepath.EvalSymlinks(link)
+		if err != nil {
+			// dangling links belong to volumes that are being detached
+			continue
+		}
+
+		if target == resolved {
+			return volumeCondition{message: "volume is healthy"}, nil
+		}
+	}
+
+	return volumeCondition{
+		abnormal: true,
+		message:  fmt.Sprintf("no %s link to device %s exists in %s anymore", diskDOPrefix, resolved, diskIDPath),
+	}, nil
+}
+
+// findMountInfo returns the mount at the given target. If several mounts are
+// stacked on the target, the topmost one is returned.
+func findMountInfo(mountInfos []mount.MountInfo, target string) (mount.MountInfo, bool) {
+	for i := len(mountInfos) - 1; i >= 0; i-- {
+		if mountInfos[i].MountPoint == target {
+			return mountInfos[i], true
+		}
+	}
+	return mount.MountInfo{}, false
+}
+
+// stagedReadOnly returns whether the filesystem of the given mount has been
+// mounted read-only on purpose, i.e. whether its staging mount is read-only.
+// If the staging path is unknown, the first mount of the filesystem is taken
+// as the staging mount since it is mounted before any publication.
+func stagedReadOnly(mountInfos []mount.MountInfo, mi mount.MountInfo, stagingPath string) bool {
+	var staging mount.MountInfo
+	var ok bool
+	if stagingPath != "" {
+		staging, ok = findMountInfo(mountInfos, stagingPath)
+	} else {
+		for _, candidate := range mountInfos {
+			if candidate.Major == mi.Major && candidate.Minor == mi.Minor {
+				staging, ok = candidate, true
+				break
+			}
+		}
+	}
+	return ok && hasOption(staging.MountOptions, "ro")
+}
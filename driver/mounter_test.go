@@ -0,0 +1,786 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWaitForDevice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wait-for-device")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// do not rescan the SCSI hosts of the machine running the tests
+	scanFile := filepath.Join(dir, "scan")
+	if err := ioutil.WriteFile(scanFile, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer func(glob string) { scsiHostScanGlob = glob }(scsiHostScanGlob)
+	scsiHostScanGlob = scanFile
+
+	// the devices of the machine running the tests are unknown as well
+	defer func(path string) { sysClassBlockPath = path }(sysClassBlockPath)
+	sysClassBlockPath = filepath.Join(dir, "block")
+
+	tests := []struct {
+		name     string
+		appearIn time.Duration
+		wantErr  bool
+	}{
+		{
+			name:     "device exists",
+			appearIn: 0,
+		},
+		{
+			name:     "device appears",
+			appearIn: 2 * devicePollInterval,
+		},
+		{
+			name:     "device never appears",
+			appearIn: -1,
+			wantErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			devicePath := filepath.Join(dir, "scsi-0DO_Volume_"+randString(5))
+			create := func() {
+				if err := ioutil.WriteFile(devicePath, nil, 0600); err != nil {
+					t.Error(err)
+				}
+			}
+
+			switch {
+			case test.appearIn == 0:
+				create()
+			case test.appearIn > 0:
+				time.AfterFunc(test.appearIn, create)
+			}
+
+			m := newMounter(logrus.New().WithField("test_enabed", true))
+			ctx, cancel := context.WithTimeout(context.Background(), 5*devicePollInterval)
+			defer cancel()
+
+			err := m.WaitForDevice(ctx, devicePath)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error: %t", err, test.wantErr)
+			}
+		})
+	}
+
+	scan, err := ioutil.ReadFile(scanFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(scan) != "- - -" {
+		t.Errorf("got SCSI host scan %q, want %q", scan, "- - -")
+	}
+}
+
+func TestUdevTriggerArgs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "udev-trigger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(path string) { sysClassBlockPath = path }(sysClassBlockPath)
+	sysClassBlockPath = filepath.Join(dir, "sys", "class", "block")
+
+	serialPage := func(serial string) []byte {
+		return append([]byte{0x00, 0x80, 0x00, byte(len(serial))}, serial...)
+	}
+	files := map[string][]byte{
+		filepath.Join(sysClassBlockPath, "sda", "device", "vpd_pg80"):  serialPage("pvc-other"),
+		filepath.Join(sysClassBlockPath, "sdb", "device", "vpd_pg80"):  serialPage("pvc-123"),
+		filepath.Join(sysClassBlockPath, "sdb1", "device", "vpd_pg80"): serialPage("pvc-123"),
+		filepath.Join(sysClassBlockPath, "sdb1", "partition"):          []byte("1\n"),
+	}
+	for path, data := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		devicePath string
+		wantArgs   []string
+	}{
+		{
+			name:       "device known to the kernel",
+			devicePath: "/dev/disk/by-id/scsi-0DO_Volume_pvc-123",
+			wantArgs:   []string{"trigger", "--action=add", "--subsystem-match=block", "--sysname-match=sdb"},
+		},
+		{
+			name:       "device unknown to the kernel",
+			devicePath: "/dev/disk/by-id/scsi-0DO_Volume_pvc-456",
+			wantArgs:   []string{"trigger", "--action=add", "--subsystem-match=block", "--property-match=ID_SERIAL=0DO_Volume_pvc-456"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := udevTriggerArgs(test.devicePath); !reflect.DeepEqual(got, test.wantArgs) {
+				t.Errorf("got arguments %q, want %q", got, test.wantArgs)
+			}
+		})
+	}
+}
+
+func TestGetDeviceIdentifiers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "device-identifiers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(path string) { sysClassBlockPath = path }(sysClassBlockPath)
+	sysClassBlockPath = filepath.Join(dir, "sys", "class", "block")
+
+	diskPath := filepath.Join(dir, "sys", "devices", "host0", "block", "sdz")
+	for _, path := range []string{
+		filepath.Join(diskPath, "device"),
+		filepath.Join(diskPath, "sdz1"),
+		sysClassBlockPath,
+		filepath.Join(dir, "dev"),
+	} {
+		if err := os.MkdirAll(path, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files := map[string][]byte{
+		filepath.Join(diskPath, "device", "vpd_pg80"): append([]byte{0x00, 0x80, 0x00, 0x0a}, "vol-serial"...),
+		filepath.Join(diskPath, "device", "vpd_pg83"): append([]byte{
+			0x00, 0x83, 0x00, 0x1c,
+			// NAA designator in binary
+			0x01, 0x03, 0x00, 0x08, 0x60, 0x01, 0x40, 0x5a, 0xbc, 0xde, 0xf0, 0x12,
+			// T10 vendor ID designator in ASCII
+			0x02, 0x01, 0x00, 0x0c,
+		}, "DO      vol "...),
+		filepath.Join(diskPath, "device", "wwid"):    []byte("naa.6001405abcdef012\n"),
+		filepath.Join(diskPath, "sdz1", "partition"): []byte("1\n"),
+		filepath.Join(dir, "dev", "sdz"):             nil,
+		filepath.Join(dir, "dev", "sdz1"):            nil,
+	}
+	for path, data := range files {
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	links := map[string]string{
+		filepath.Join(sysClassBlockPath, "sdz"):         diskPath,
+		filepath.Join(sysClassBlockPath, "sdz1"):        filepath.Join(diskPath, "sdz1"),
+		filepath.Join(dir, "scsi-0DO_Volume_vol"):       filepath.Join(dir, "dev", "sdz"),
+		filepath.Join(dir, "scsi-0DO_Volume_vol-part1"): filepath.Join(dir, "dev", "sdz1"),
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"vol-serial", "6001405abcdef012", "DO      vol", "naa.6001405abcdef012"}
+
+	m := newMounter(logrus.New().WithField("test_enabled", true))
+	for _, devicePath := range []string{"scsi-0DO_Volume_vol", "scsi-0DO_Volume_vol-part1"} {
+		t.Run(devicePath, func(t *testing.T) {
+			got, err := m.GetDeviceIdentifiers(filepath.Join(dir, devicePath))
+			if err != nil {
+				t.Fatalf("got error: %s", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got identifiers %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestMatchDeviceIdentifiers(t *testing.T) {
+	const volumeID = "7724db7c-e098-11e5-b522-000f53304e51"
+
+	tests := []struct {
+		name        string
+		identifiers []string
+		wantOK      bool
+	}{
+		{
+			name:        "serial is the volume ID",
+			identifiers: []string{volumeID},
+			wantOK:      true,
+		},
+		{
+			name:        "WWN contains the volume ID",
+			identifiers: []string{"naa.6001405a7724db7ce09811e5b522000f53304e51"},
+			wantOK:      true,
+		},
+		{
+			name:        "serial is the volume name",
+			identifiers: []string{"naa.6001405abcdef012", "pvc-123"},
+		},
+		{
+			name:        "T10 vendor ID contains the volume name",
+			identifiers: []string{"t10.DO      Volume  pvc-123"},
+		},
+		{
+			name:        "ID of another volume",
+			identifiers: []string{"8824db7c-e098-11e5-b522-000f53304e51"},
+		},
+		{
+			name: "no identifiers",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if ok := matchDeviceIdentifiers(test.identifiers, volumeID); ok != test.wantOK {
+				t.Errorf("got ok %t, want %t", ok, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestVerifyDeviceIdentity(t *testing.T) {
+	const volumeID = "7724db7c-e098-11e5-b522-000f53304e51"
+
+	tests := []struct {
+		name              string
+		publishedVolumeID string
+		deviceVolume      *godo.Volume
+		wantCode          codes.Code
+	}{
+		{
+			name:              "device of the volume",
+			publishedVolumeID: volumeID,
+			deviceVolume:      &godo.Volume{ID: volumeID, Name: "pvc-123"},
+		},
+		{
+			name:              "device of another volume with the same name",
+			publishedVolumeID: volumeID,
+			deviceVolume:      &godo.Volume{ID: "8824db7c-e098-11e5-b522-000f53304e51", Name: "pvc-123"},
+			wantCode:          codes.FailedPrecondition,
+		},
+		{
+			name:              "device without identifiers",
+			publishedVolumeID: volumeID,
+			wantCode:          codes.FailedPrecondition,
+		},
+		{
+			name:              "published as another volume",
+			publishedVolumeID: "8824db7c-e098-11e5-b522-000f53304e51",
+			deviceVolume:      &godo.Volume{ID: volumeID, Name: "pvc-123"},
+			wantCode:          codes.FailedPrecondition,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			volumes := map[string]*godo.Volume{}
+			if test.deviceVolume != nil {
+				volumes[test.deviceVolume.ID] = test.deviceVolume
+			}
+			d := &Driver{
+				mounter: &fakeMounter{volumes: volumes},
+			}
+
+			log := logrus.New().WithField("test_enabed", true)
+			err := d.verifyDeviceIdentity(volumeID, test.publishedVolumeID, getDeviceByIDPath("pvc-123"), log)
+			if code := status.Code(err); code != test.wantCode {
+				t.Errorf("got code %s, want %s (error: %v)", code, test.wantCode, err)
+			}
+		})
+	}
+}
+
+func TestPartitions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "partitions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(path string) { sysClassBlockPath = path }(sysClassBlockPath)
+	sysClassBlockPath = filepath.Join(dir, "sys", "class", "block")
+
+	diskPath := filepath.Join(dir, "sys", "devices", "host0", "block", "sdz")
+	mapperPath := filepath.Join(dir, "sys", "devices", "virtual", "block", "dm-0")
+	for _, path := range []string{
+		filepath.Join(diskPath, "sdz1"),
+		filepath.Join(diskPath, "sdz2"),
+		filepath.Join(diskPath, "queue"),
+		filepath.Join(mapperPath, "slaves"),
+		sysClassBlockPath,
+		filepath.Join(dir, "dev"),
+	} {
+		if err := os.MkdirAll(path, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for path, data := range map[string]string{
+		filepath.Join(diskPath, "sdz1", "partition"): "1\n",
+		filepath.Join(diskPath, "sdz2", "partition"): "2\n",
+		filepath.Join(dir, "dev", "sdz"):             "",
+		filepath.Join(dir, "dev", "sdz2"):            "",
+		filepath.Join(dir, "dev", "dm-0"):            "",
+	} {
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for link, target := range map[string]string{
+		filepath.Join(sysClassBlockPath, "sdz"):         diskPath,
+		filepath.Join(sysClassBlockPath, "sdz2"):        filepath.Join(diskPath, "sdz2"),
+		filepath.Join(sysClassBlockPath, "dm-0"):        mapperPath,
+		filepath.Join(mapperPath, "slaves", "sdz2"):     filepath.Join(diskPath, "sdz2"),
+		filepath.Join(dir, "scsi-0DO_Volume_vol"):       filepath.Join(dir, "dev", "sdz"),
+		filepath.Join(dir, "scsi-0DO_Volume_vol-part2"): filepath.Join(dir, "dev", "sdz2"),
+	} {
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("find partition by number", func(t *testing.T) {
+		m := newMounter(logrus.New().WithField("test_enabled", true))
+		for number, want := range map[int]string{1: "/dev/sdz1", 2: "/dev/sdz2", 3: ""} {
+			got, err := m.FindPartition(filepath.Join(dir, "scsi-0DO_Volume_vol"), partitionSelector{number: number})
+			if err != nil {
+				t.Fatalf("got error: %s", err)
+			}
+			if got != want {
+				t.Errorf("got partition %q for number %d, want %q", got, number, want)
+			}
+		}
+	})
+
+	tests := []struct {
+		name       string
+		devicePath string
+		wantDisk   string
+		wantNumber int
+		wantOK     bool
+	}{
+		{
+			name:       "whole disk",
+			devicePath: filepath.Join(dir, "scsi-0DO_Volume_vol"),
+		},
+		{
+			name:       "partition",
+			devicePath: filepath.Join(dir, "scsi-0DO_Volume_vol-part2"),
+			wantDisk:   "/dev/sdz",
+			wantNumber: 2,
+			wantOK:     true,
+		},
+		{
+			name:       "luks mapping on partition",
+			devicePath: filepath.Join(dir, "dev", "dm-0"),
+			wantDisk:   "/dev/sdz",
+			wantNumber: 2,
+			wantOK:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			disk, number, ok, err := getBackingPartition(test.devicePath)
+			if err != nil {
+				t.Fatalf("got error: %s", err)
+			}
+			if ok != test.wantOK || disk != test.wantDisk || number != test.wantNumber {
+				t.Errorf("got (%q, %d, %t), want (%q, %d, %t)", disk, number, ok, test.wantDisk, test.wantNumber, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestGetVolumeCondition(t *testing.T) {
+	dir, err := ioutil.TempDir("", "volume-condition")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(path string) { diskIDPath = path }(diskIDPath)
+	diskIDPath = filepath.Join(dir, "by-id")
+	defer func(path string) { mountInfoPath = path }(mountInfoPath)
+	mountInfoPath = filepath.Join(dir, "mountinfo")
+
+	devDir := filepath.Join(dir, "dev")
+	staging := filepath.Join(dir, "staging")
+	target := filepath.Join(dir, "target")
+	for _, path := range []string{diskIDPath, devDir, staging, target} {
+		if err := os.MkdirAll(path, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, device := range []string{"sdb", "sdc"} {
+		if err := ioutil.WriteFile(filepath.Join(devDir, device), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, device := range map[string]string{
+		"pvc-1":        "sdb",
+		"pvc-luks":     "sdb",
+		"pvc-detached": "sdx",
+	} {
+		if err := os.Symlink(filepath.Join(devDir, device), filepath.Join(diskIDPath, diskDOPrefix+name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type mountEntry struct {
+		source, options, superOptions string
+	}
+
+	tests := []struct {
+		name string
+		// staging is mounted before the mounts of the target
+		staging       *mountEntry
+		passStaging   bool
+		mounts        []mountEntry
+		keyLocation   string
+		wantAbnormal  bool
+		wantMsgSubstr string
+		wantErr       bool
+	}{
+		{
+			name:          "healthy",
+			mounts:        []mountEntry{{filepath.Join(devDir, "sdb"), "rw,relatime", "rw"}},
+			wantMsgSubstr: "volume is healthy",
+		},
+		{
+			name:          "read-only bind mount",
+			mounts:        []mountEntry{{filepath.Join(devDir, "sdb"), "ro,relatime", "rw"}},
+			wantMsgSubstr: "volume is healthy",
+		},
+		{
+			name:          "filesystem remounted read-only",
+			mounts:        []mountEntry{{filepath.Join(devDir, "sdb"), "rw,relatime", "ro,errors=remount-ro"}},
+			wantAbnormal:  true,
+			wantMsgSubstr: "has been remounted read-only",
+		},
+		{
+			name: "topmost mount remounted read-only",
+			mounts: []mountEntry{
+				{filepath.Join(devDir, "sdb"), "rw,relatime", "rw"},
+				{filepath.Join(devDir, "sdb"), "rw,relatime", "ro"},
+			},
+			wantAbnormal:  true,
+			wantMsgSubstr: "has been remounted read-only",
+		},
+		{
+			name:          "volume staged read-only",
+			staging:       &mountEntry{filepath.Join(devDir, "sdb"), "ro,relatime", "ro"},
+			passStaging:   true,
+			mounts:        []mountEntry{{filepath.Join(devDir, "sdb"), "rw,relatime", "ro"}},
+			wantMsgSubstr: "volume is healthy",
+		},
+		{
+			name:          "volume staged read-only with unknown staging path",
+			staging:       &mountEntry{filepath.Join(devDir, "sdb"), "ro,relatime", "ro"},
+			mounts:        []mountEntry{{filepath.Join(devDir, "sdb"), "rw,relatime", "ro"}},
+			wantMsgSubstr: "volume is healthy",
+		},
+		{
+			name:          "staged volume remounted read-only",
+			staging:       &mountEntry{filepath.Join(devDir, "sdb"), "rw,relatime", "ro,errors=remount-ro"},
+			passStaging:   true,
+			mounts:        []mountEntry{{filepath.Join(devDir, "sdb"), "rw,relatime", "ro,errors=remount-ro"}},
+			wantAbnormal:  true,
+			wantMsgSubstr: "has been remounted read-only",
+		},
+		{
+			name:          "staged volume published read-only and remounted read-only",
+			staging:       &mountEntry{filepath.Join(devDir, "sdb"), "rw,relatime", "ro,errors=remount-ro"},
+			passStaging:   true,
+			mounts:        []mountEntry{{filepath.Join(devDir, "sdb"), "ro,relatime", "ro,errors=remount-ro"}},
+			wantAbnormal:  true,
+			wantMsgSubstr: "has been remounted read-only",
+		},
+		{
+			name:          "device disappeared",
+			mounts:        []mountEntry{{filepath.Join(devDir, "sdx"), "rw", "rw"}},
+			wantAbnormal:  true,
+			wantMsgSubstr: "has disappeared",
+		},
+		{
+			name:          "by-id link removed",
+			mounts:        []mountEntry{{filepath.Join(devDir, "sdc"), "rw", "rw"}},
+			wantAbnormal:  true,
+			wantMsgSubstr: "no " + diskDOPrefix + " link",
+		},
+		{
+			name:          "luks mapping healthy",
+			mounts:        []mountEntry{{"/dev/mapper/pvc-luks", "rw", "rw"}},
+			keyLocation:   "keyring",
+			wantMsgSubstr: "volume is healthy",
+		},
+		{
+			name:          "luks mapping closed",
+			mounts:        []mountEntry{{"/dev/mapper/pvc-luks", "rw", "rw"}},
+			wantAbnormal:  true,
+			wantMsgSubstr: "luks mapping pvc-luks is closed",
+		},
+		{
+			name:          "device backing luks mapping disappeared",
+			mounts:        []mountEntry{{"/dev/mapper/pvc-gone", "rw", "rw"}},
+			keyLocation:   "keyring",
+			wantAbnormal:  true,
+			wantMsgSubstr: "backing luks mapping pvc-gone has disappeared",
+		},
+		{
+			name:    "not mounted",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mountInfo := "22 1 253:1 / / rw,relatime shared:1 - ext4 /dev/vda1 rw\n"
+			if m := test.staging; m != nil {
+				mountInfo += fmt.Sprintf("99 22 8:16 / %s %s - ext4 %s %s\n", staging, m.options, m.source, m.superOptions)
+			}
+			for i, m := range test.mounts {
+				mountInfo += fmt.Sprintf("%d 22 8:16 / %s %s - ext4 %s %s\n", 100+i, target, m.options, m.source, m.superOptions)
+			}
+			if err := ioutil.WriteFile(mountInfoPath, []byte(mountInfo), 0600); err != nil {
+				t.Fatal(err)
+			}
+			fakeCryptsetup(t, test.keyLocation)
+
+			var stagingPath string
+			if test.passStaging {
+				stagingPath = staging
+			}
+
+			m := newMounter(logrus.New().WithField("test_enabed", true))
+			condition, err := m.GetVolumeCondition(target, stagingPath)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error: %t", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+
+			if condition.abnormal != test.wantAbnormal {
+				t.Errorf("got abnormal %t, want %t", condition.abnormal, test.wantAbnormal)
+			}
+			if !strings.Contains(condition.message, test.wantMsgSubstr) {
+				t.Errorf("want condition message %q to include %q", condition.message, test.wantMsgSubstr)
+			}
+		})
+	}
+}
+
+func TestGetBlockVolumeCondition(t *testing.T) {
+	// a device node is needed to resolve the device number from, and
+	// creating one requires privileges
+	const devicePath = "/dev/null"
+	var stat unix.Stat_t
+	if err := unix.Stat(devicePath, &stat); err != nil {
+		t.Skipf("cannot stat %s: %s", devicePath, err)
+	}
+	deviceNumber := fmt.Sprintf("%d:%d", unix.Major(uint64(stat.Rdev)), unix.Minor(uint64(stat.Rdev)))
+
+	tests := []struct {
+		name          string
+		sysfsEntry    bool
+		byIDLink      bool
+		wantAbnormal  bool
+		wantMsgSubstr string
+	}{
+		{
+			name:          "healthy",
+			sysfsEntry:    true,
+			byIDLink:      true,
+			wantMsgSubstr: "volume is healthy",
+		},
+		{
+			name:          "by-id link removed",
+			sysfsEntry:    true,
+			wantAbnormal:  true,
+			wantMsgSubstr: "no " + diskDOPrefix + " link to device " + devicePath,
+		},
+		{
+			name:          "device disappeared",
+			byIDLink:      true,
+			wantAbnormal:  true,
+			wantMsgSubstr: "block device bind mounted to " + devicePath + " has disappeared",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "block-volume-condition")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			defer func(path string) { diskIDPath = path }(diskIDPath)
+			diskIDPath = filepath.Join(dir, "by-id")
+			defer func(path string) { sysDevBlockPath = path }(sysDevBlockPath)
+			sysDevBlockPath = filepath.Join(dir, "sys", "dev", "block")
+
+			sysDevicePath := filepath.Join(dir, "sys", "devices", filepath.Base(devicePath))
+			for _, path := range []string{diskIDPath, sysDevBlockPath, sysDevicePath} {
+				if err := os.MkdirAll(path, 0700); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.sysfsEntry {
+				if err := os.Symlink(sysDevicePath, filepath.Join(sysDevBlockPath, deviceNumber)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.byIDLink {
+				if err := os.Symlink(devicePath, filepath.Join(diskIDPath, diskDOPrefix+"pvc-1")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			condition, err := getBlockVolumeCondition(devicePath)
+			if err != nil {
+				t.Fatalf("got error: %s", err)
+			}
+
+			if condition.abnormal != test.wantAbnormal {
+				t.Errorf("got abnormal %t, want %t", condition.abnormal, test.wantAbnormal)
+			}
+			if !strings.Contains(condition.message, test.wantMsgSubstr) {
+				t.Errorf("want condition message %q to include %q", condition.message, test.wantMsgSubstr)
+			}
+		})
+	}
+}
+
+func TestGetDeviceCondition(t *testing.T) {
+	dir, err := ioutil.TempDir("", "device-condition")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(path string) { diskIDPath = path }(diskIDPath)
+	diskIDPath = filepath.Join(dir, "by-id")
+
+	devDir := filepath.Join(dir, "dev")
+	for _, path := range []string{diskIDPath, devDir} {
+		if err := os.MkdirAll(path, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, device := range []string{"sdb", "sdb1", "sdc", "dm-0"} {
+		if err := ioutil.WriteFile(filepath.Join(devDir, device), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		filepath.Join(diskIDPath, diskDOPrefix+"pvc-1"):       filepath.Join(devDir, "sdb"),
+		filepath.Join(diskIDPath, diskDOPrefix+"pvc-1-part1"): filepath.Join(devDir, "sdb1"),
+		filepath.Join(diskIDPath, diskDOPrefix+"pvc-2"):       filepath.Join(devDir, "sdx"),
+		filepath.Join(diskIDPath, "dm-name-other"):            filepath.Join(devDir, "dm-0"),
+		filepath.Join(devDir, "volume"):                       filepath.Join(devDir, "sdb"),
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name          string
+		device        string
+		wantAbnormal  bool
+		wantMsgSubstr string
+	}{
+		{
+			name:          "disk",
+			device:        filepath.Join(devDir, "sdb"),
+			wantMsgSubstr: "volume is healthy",
+		},
+		{
+			name:          "partition",
+			device:        filepath.Join(devDir, "sdb1"),
+			wantMsgSubstr: "volume is healthy",
+		},
+		{
+			name:          "symlink to disk",
+			device:        filepath.Join(devDir, "volume"),
+			wantMsgSubstr: "volume is healthy",
+		},
+		{
+			name:          "device disappeared",
+			device:        filepath.Join(devDir, "sdx"),
+			wantAbnormal:  true,
+			wantMsgSubstr: "has disappeared",
+		},
+		{
+			name:          "no DigitalOcean link",
+			device:        filepath.Join(devDir, "sdc"),
+			wantAbnormal:  true,
+			wantMsgSubstr: "no " + diskDOPrefix + " link",
+		},
+		{
+			name:          "only linked by other devices",
+			device:        filepath.Join(devDir, "dm-0"),
+			wantAbnormal:  true,
+			wantMsgSubstr: "no " + diskDOPrefix + " link",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			condition, err := getDeviceCondition(test.device)
+			if err != nil {
+				t.Fatalf("got error: %s", err)
+			}
+
+			if condition.abnormal != test.wantAbnormal {
+				t.Errorf("got abnormal %t, want %t", condition.abnormal, test.wantAbnormal)
+			}
+			if !strings.Contains(condition.message, test.wantMsgSubstr) {
+				t.Errorf("want condition message %q to include %q", condition.message, test.wantMsgSubstr)
+			}
+		})
+	}
+}
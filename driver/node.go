@@ -26,6 +26,7 @@ package driver
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"path/filepath"
 	"strings"
@@ -39,13 +40,12 @@ import (
 	utilexec "k8s.io/utils/exec"
 )
 
+// diskIDPath is the directory holding the by-id links of the disks.
+var diskIDPath = "/dev/disk/by-id"
+
 const (
-	diskIDPath   = "/dev/disk/by-id"
 	diskDOPrefix = "scsi-0DO_Volume_"
 
-	// See: https://www.digitalocean.com/docs/volumes/overview/#limits
-	maxVolumesPerNode = 7
-
 	volumeModeBlock      = "block"
 	volumeModeFilesystem = "filesystem"
 )
@@ -106,6 +106,38 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 	}
 
 	source := getDeviceByIDPath(volumeName)
+	if err := d.waitForDevice(ctx, source, volumeName); err != nil {
+		return nil, err
+	}
+
+	if err := d.verifyDeviceIdentity(req.VolumeId, publishContext[d.publishInfoVolumeID], source, log); err != nil {
+		return nil, err
+	}
+
+	if value, ok := req.VolumeContext[PartitionAttribute]; ok {
+		selector, err := parsePartitionSelector(value)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		partition, err := d.mounter.FindPartition(source, selector)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to find partition %s of volume %s: %s", selector, req.VolumeId, err)
+		}
+		if partition == "" {
+			return nil, status.Errorf(codes.FailedPrecondition, "partition %s does not exist on volume %s", selector, req.VolumeId)
+		}
+
+		log.WithFields(logrus.Fields{
+			"partition":        selector.String(),
+			"partition_device": partition,
+		}).Info("staging partition of the volume")
+		source = partition
+	}
+
+	if err := d.checkForeignSignatures(req.VolumeId, source, req.VolumeContext, log); err != nil {
+		return nil, err
+	}
 
 	luksContext := getLuksContext(req.Secrets, req.VolumeContext, VolumeLifecycleNodeStageVolume)
 
@@ -114,7 +146,7 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 	mnt := req.VolumeCapability.GetMount()
 	options := mnt.MountFlags
 
-	fsType := "ext4"
+	fsType := defaultFsType
 	if mnt.FsType != "" {
 		fsType = mnt.FsType
 	}
@@ -130,6 +162,15 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 		"luks_encrypted":  luksContext.EncryptionEnabled,
 	})
 
+	// the previous key must be replaced before the volume is opened with the
+	// current key below
+	if luksContext.PreviousEncryptionKey != "" {
+		log.Info("rotating luks key")
+		if err := d.mounter.RotateLuksKey(source, luksContext); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to rotate luks key: %s", err)
+		}
+	}
+
 	var noFormat bool
 	for _, ann := range annsNoFormatVolume {
 		_, noFormat = req.VolumeContext[ann]
@@ -146,8 +187,13 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 		}
 
 		if !formatted {
-			log.Info("formatting the volume for staging")
-			if err := d.mounter.Format(source, fsType, luksContext); err != nil {
+			mkfsOptions, err := parseMkfsOptions(fsType, req.VolumeContext[MkfsOptionsAttribute])
+			if err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+
+			log.WithField("mkfs_options", mkfsOptions).Info("formatting the volume for staging")
+			if err := d.mounter.Format(source, fsType, mkfsOptions, luksContext); err != nil {
 				return nil, status.Error(codes.Internal, err.Error())
 			}
 		} else {
@@ -254,7 +300,7 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 	var err error
 	switch req.GetVolumeCapability().GetAccessType().(type) {
 	case *csi.VolumeCapability_Block:
-		err = d.nodePublishVolumeForBlock(req, luksContext, options, log)
+		err = d.nodePublishVolumeForBlock(ctx, req, luksContext, options, log)
 	case *csi.VolumeCapability_Mount:
 		err = d.nodePublishVolumeForFileSystem(req, luksContext, options, log)
 	default:
@@ -294,11 +340,24 @@ func (d *Driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublish
 	}
 
 	if mounted {
+		// the device of a raw block volume can only be told from the
+		// target while it is still mounted
+		device, err := d.mounter.GetPublishedBlockDevice(req.TargetPath)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to find the block device published to %s: %s", req.TargetPath, err)
+		}
+
 		log.Info("unmounting the target path")
-		err := d.mounter.Unmount(req.TargetPath, luksContext)
+		err = d.mounter.Unmount(req.TargetPath, luksContext)
 		if err != nil {
 			return nil, err
 		}
+
+		if device != "" {
+			if err := d.resetBlockDeviceReadOnly(req.VolumeId, device, log); err != nil {
+				return nil, err
+			}
+		}
 	} else {
 		log.Info("target path is already unmounted")
 	}
@@ -331,6 +390,20 @@ func (d *Driver) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabi
 				},
 			},
 		},
+		&csi.NodeServiceCapability{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{
+					Type: csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
+				},
+			},
+		},
+		&csi.NodeServiceCapability{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{
+					Type: csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+				},
+			},
+		},
 	}
 
 	d.log.WithFields(logrus.Fields{
@@ -347,20 +420,47 @@ func (d *Driver) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabi
 // knows where to place the workload. The result of this function will be used
 // by the CO in ControllerPublishVolume.
 func (d *Driver) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
-	d.log.WithField("method", "node_get_info").Info("node get info called")
+	log := d.log.WithField("method", "node_get_info")
+	log.Info("node get info called")
+
 	return &csi.NodeGetInfoResponse{
 		NodeId:            d.hostID(),
-		MaxVolumesPerNode: maxVolumesPerNode,
+		MaxVolumesPerNode: d.attachLimit(log),
 
 		// make sure that the driver works on this particular region only
 		AccessibleTopology: &csi.Topology{
-			Segments: map[string]string{
-				"region": d.region,
-			},
+			Segments: d.topologySegments(d.region),
 		},
 	}, nil
 }
 
+// attachLimit returns the number of volumes that can be attached to the node
+// through CSI: the per-node limit minus the volumes attached by other means.
+func (d *Driver) attachLimit(log *logrus.Entry) int64 {
+	limit := d.maxVolumesPerNode
+
+	unmanaged, err := d.mounter.CountUnmanagedVolumes(d.name)
+	if err != nil {
+		log.WithError(err).Warn("failed to count volumes not managed by CSI, assuming there are none")
+		return int64(limit)
+	}
+
+	log = log.WithFields(logrus.Fields{
+		"max_volumes_per_node": limit,
+		"unmanaged_volumes":    unmanaged,
+	})
+
+	limit -= unmanaged
+	// a limit of zero means unlimited to the CO
+	if limit < 1 {
+		log.Warn("volumes not managed by CSI occupy all attachment slots")
+		limit = 1
+	}
+
+	log.WithField("attach_limit", limit).Info("determined attach limit")
+	return int64(limit)
+}
+
 // NodeGetVolumeStats returns the volume capacity statistics available for the
 // the given volume.
 func (d *Driver) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
@@ -389,6 +489,25 @@ func (d *Driver) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeS
 		return nil, status.Errorf(codes.NotFound, "volume path %q is not mounted", volumePath)
 	}
 
+	condition, err := d.mounter.GetVolumeCondition(volumePath, req.StagingTargetPath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to determine condition of volume path %q: %s", volumePath, err)
+	}
+
+	volumeCondition := &csi.VolumeCondition{
+		Abnormal: condition.abnormal,
+		Message:  condition.message,
+	}
+
+	// gathering statistics from a volume that lost its device may block or
+	// fail, so we only report the condition
+	if condition.abnormal {
+		log.WithField("volume_condition", condition.message).Warn("volume is in abnormal condition")
+		return &csi.NodeGetVolumeStatsResponse{
+			VolumeCondition: volumeCondition,
+		}, nil
+	}
+
 	isBlock, err := d.mounter.IsBlockDevice(volumePath)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to determine if %q is block device: %s", volumePath, err)
@@ -413,6 +532,7 @@ func (d *Driver) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeS
 					Total: stats.totalBytes,
 				},
 			},
+			VolumeCondition: volumeCondition,
 		}, nil
 	}
 
@@ -441,6 +561,7 @@ func (d *Driver) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeS
 				Unit:      csi.VolumeUsage_INODES,
 			},
 		},
+		VolumeCondition: volumeCondition,
 	}, nil
 }
 
@@ -497,6 +618,27 @@ func (d *Driver) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolume
 	log = log.WithFields(logrus.Fields{
 		"device_path": devicePath,
 	})
+
+	// volumes adopted with a partition only grow if the partition grows
+	if err := d.mounter.GrowPartition(devicePath); err != nil {
+		return nil, status.Errorf(codes.Internal, "NodeExpandVolume could not grow partition of %q: %v", devicePath, err)
+	}
+
+	// the filesystem of an encrypted volume can only grow once the luks
+	// mapping it lives on has been grown. The key is taken from the
+	// node-expand secrets; it may only be missing if the volume key is kept
+	// in the dm-crypt table.
+	luksContext := LuksContext{
+		EncryptionKey:   req.GetSecrets()[LuksKeyAttribute],
+		VolumeLifecycle: VolumeLifecycleNodeExpandVolume,
+	}
+	if err := d.mounter.ResizeLuksMapping(devicePath, luksContext); err != nil {
+		if errors.Is(err, errLuksKeyRequired) {
+			return nil, status.Errorf(codes.FailedPrecondition, "NodeExpandVolume cannot resize luks mapping %q without the luks key: %v", devicePath, err)
+		}
+		return nil, status.Errorf(codes.Internal, "NodeExpandVolume could not resize luks mapping %q: %v", devicePath, err)
+	}
+
 	log.Info("resizing volume")
 	if _, err := r.Resize(devicePath, volumePath); err != nil {
 		return nil, status.Errorf(codes.Internal, "NodeExpandVolume could not resize volume %q (%q):  %v", volumeID, req.GetVolumePath(), err)
@@ -515,7 +657,7 @@ func (d *Driver) nodePublishVolumeForFileSystem(req *csi.NodePublishVolumeReques
 		mountOptions = append(mountOptions, flag)
 	}
 
-	fsType := "ext4"
+	fsType := defaultFsType
 	if mnt.FsType != "" {
 		fsType = mnt.FsType
 	}
@@ -533,6 +675,10 @@ func (d *Driver) nodePublishVolumeForFileSystem(req *csi.NodePublishVolumeReques
 	})
 
 	if !mounted {
+		if err := d.checkSingleWriter(req, source, false); err != nil {
+			return err
+		}
+
 		log.Info("mounting the volume")
 		if err := d.mounter.Mount(source, target, fsType, luksContext, mountOptions...); err != nil {
 			return status.Error(codes.Internal, err.Error())
@@ -544,12 +690,16 @@ func (d *Driver) nodePublishVolumeForFileSystem(req *csi.NodePublishVolumeReques
 	return nil
 }
 
-func (d *Driver) nodePublishVolumeForBlock(req *csi.NodePublishVolumeRequest, luksContext LuksContext, mountOptions []string, log *logrus.Entry) error {
+func (d *Driver) nodePublishVolumeForBlock(ctx context.Context, req *csi.NodePublishVolumeRequest, luksContext LuksContext, mountOptions []string, log *logrus.Entry) error {
 	volumeName, ok := req.GetPublishContext()[d.publishInfoVolumeName]
 	if !ok {
 		return status.Error(codes.InvalidArgument, fmt.Sprintf("Could not find the volume name from the publish context %q", d.publishInfoVolumeName))
 	}
 
+	if err := d.waitForDevice(ctx, getDeviceByIDPath(volumeName), volumeName); err != nil {
+		return err
+	}
+
 	source, err := findAbsoluteDeviceByIDPath(volumeName)
 	if err != nil {
 		return status.Errorf(codes.Internal, "Failed to find device path for volume %s. %v", volumeName, err)
@@ -568,6 +718,18 @@ func (d *Driver) nodePublishVolumeForBlock(req *csi.NodePublishVolumeRequest, lu
 		"mount_options": mountOptions,
 	})
 
+	if !mounted {
+		if err := d.checkSingleWriter(req, source, true); err != nil {
+			return err
+		}
+	}
+
+	// the flag must be in place before the device becomes reachable through
+	// the target
+	if err := d.setBlockDeviceReadOnly(req.VolumeId, source, target, req.Readonly, log); err != nil {
+		return err
+	}
+
 	if !mounted {
 		log.Info("mounting the volume")
 		if err := d.mounter.Mount(source, target, "", luksContext, mountOptions...); err != nil {
@@ -580,6 +742,168 @@ func (d *Driver) nodePublishVolumeForBlock(req *csi.NodePublishVolumeRequest, lu
 	return nil
 }
 
+// setBlockDeviceReadOnly flags the given block device read-only or
+// read-write for its publication to the given target. A read-only bind mount
+// of a device file does not prevent writes to the device, so the device
+// itself is flagged. Since the flag is shared by all publications of the
+// device, it is only set by the first publication, and publications that
+// would need the flag the other way round are refused.
+func (d *Driver) setBlockDeviceReadOnly(volumeID, source, target string, readOnly bool, log *logrus.Entry) error {
+	targets, err := d.mounter.GetBindMountTargets(source, true)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to find the targets volume %s is published to: %s", volumeID, err)
+	}
+
+	var others []string
+	for _, t := range targets {
+		if t != target {
+			others = append(others, t)
+		}
+	}
+
+	if len(others) == 0 {
+		log.WithField("read_only", readOnly).Info("setting read-only flag of the block device")
+		if err := d.mounter.SetBlockDeviceReadOnly(source, readOnly); err != nil {
+			return status.Errorf(codes.Internal, "failed to set read-only flag of block device: %s", err)
+		}
+		return nil
+	}
+
+	current, err := d.mounter.IsBlockDeviceReadOnly(source)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to get read-only flag of block device: %s", err)
+	}
+	if current != readOnly {
+		return status.Errorf(codes.FailedPrecondition,
+			"block device of volume %s is already published %s to %q, refusing to publish it %s",
+			volumeID, accessDescription(current), others, accessDescription(readOnly))
+	}
+
+	return nil
+}
+
+// resetBlockDeviceReadOnly clears the read-only flag of the given block
+// device once it is not published to any target anymore.
+func (d *Driver) resetBlockDeviceReadOnly(volumeID, device string, log *logrus.Entry) error {
+	targets, err := d.mounter.GetBindMountTargets(device, true)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to find the targets volume %s is published to: %s", volumeID, err)
+	}
+	if len(targets) > 0 {
+		return nil
+	}
+
+	log.WithField("device_path", device).Info("clearing read-only flag of the block device")
+	if err := d.mounter.SetBlockDeviceReadOnly(device, false); err != nil {
+		return status.Errorf(codes.Internal, "failed to clear read-only flag of block device: %s", err)
+	}
+	return nil
+}
+
+func accessDescription(readOnly bool) string {
+	if readOnly {
+		return "read-only"
+	}
+	return "read-write"
+}
+
+// checkSingleWriter refuses to publish a volume with the single writer
+// access mode to the target of the request if the source is already
+// published to another target.
+func (d *Driver) checkSingleWriter(req *csi.NodePublishVolumeRequest, source string, isDevice bool) error {
+	if req.GetVolumeCapability().GetAccessMode().GetMode() != csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER {
+		return nil
+	}
+
+	targets, err := d.mounter.GetBindMountTargets(source, isDevice)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to find the targets volume %s is published to: %s", req.VolumeId, err)
+	}
+
+	for _, target := range targets {
+		if target != req.TargetPath {
+			return status.Errorf(codes.FailedPrecondition, "volume %s with single writer access mode is already published to %s", req.VolumeId, target)
+		}
+	}
+
+	return nil
+}
+
+// verifyDeviceIdentity makes sure that the device behind the given source
+// belongs to the requested volume before anything writes to it. Devices that
+// do not report any SCSI identifiers cannot be verified and are refused with
+// FailedPrecondition, just like devices whose identifiers do not carry the
+// volume ID.
+func (d *Driver) verifyDeviceIdentity(volumeID, publishedVolumeID, source string, log *logrus.Entry) error {
+	// the volume ID is only passed by controllers that verify device
+	// identities
+	if publishedVolumeID != "" && publishedVolumeID != volumeID {
+		return status.Errorf(codes.FailedPrecondition, "volume %s was published as volume %s, refusing to stage it", volumeID, publishedVolumeID)
+	}
+
+	identifiers, err := d.mounter.GetDeviceIdentifiers(source)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to read identifiers of device %s: %s", source, err)
+	}
+
+	if len(identifiers) == 0 {
+		return status.Errorf(codes.FailedPrecondition, "device %s does not report any identifiers, refusing to stage it as volume %s", source, volumeID)
+	}
+
+	if !matchDeviceIdentifiers(identifiers, volumeID) {
+		return status.Errorf(codes.FailedPrecondition,
+			"device %s does not belong to volume %s: its identifiers %q do not carry the volume ID, refusing to stage it",
+			source, volumeID, identifiers)
+	}
+
+	log.WithField("device_identifiers", identifiers).Debug("verified device identity")
+	return nil
+}
+
+// checkForeignSignatures refuses devices that carry a partition table or the
+// signature of a volume manager: formatting them would destroy data that
+// blkid does not report as a filesystem, and mounting them would use only a
+// part of the data. The check is skipped if the volume explicitly allows
+// foreign signatures.
+func (d *Driver) checkForeignSignatures(volumeID, source string, volumeContext map[string]string, log *logrus.Entry) error {
+	allow, err := allowForeignSignatures(volumeContext)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if allow {
+		log.Info("foreign signatures are allowed, skipping the check")
+		return nil
+	}
+
+	signatures, err := d.mounter.GetForeignSignatures(source)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to probe device %s for foreign signatures: %s", source, err)
+	}
+
+	if len(signatures) > 0 {
+		return status.Errorf(codes.FailedPrecondition,
+			"device %s of volume %s carries %s, refusing to format or mount it: remove the signatures (e.g. with `wipefs --all %s`) or set %s to \"true\" in the StorageClass parameters or the PersistentVolume's volume attributes to use the device as-is",
+			source, volumeID, strings.Join(signatures, ", "), source, AllowForeignSignaturesAttribute)
+	}
+
+	return nil
+}
+
+// waitForDevice waits up to the device wait timeout for the device of the
+// given volume to appear on the node.
+func (d *Driver) waitForDevice(ctx context.Context, devicePath, volumeName string) error {
+	if d.deviceWaitTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.deviceWaitTimeout)
+		defer cancel()
+	}
+
+	if err := d.mounter.WaitForDevice(ctx, devicePath); err != nil {
+		return status.Errorf(codes.Unavailable, "device for volume %q did not show up, it may not be attached to this node yet: %s", volumeName, err)
+	}
+	return nil
+}
+
 // getDeviceByIDPath returns the absolute path of the attached volume for the given
 // DO volume name
 func getDeviceByIDPath(volumeName string) string {
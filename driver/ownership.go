@@ -0,0 +1,62 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import "github.com/digitalocean/godo"
+
+// filtersOwnership returns whether the list RPCs only return the volumes and
+// snapshots owned by the driver, i.e. the ones carrying the tag given by
+// --do-tag. Without a tag, ownership cannot be told and all resources are
+// listed.
+func (d *Driver) filtersOwnership() bool {
+	return d.doTag != "" && !d.listUnownedResources
+}
+
+// ownsResource returns whether a volume or snapshot with the given tags is
+// owned by the driver.
+func (d *Driver) ownsResource(tags []string) bool {
+	if !d.filtersOwnership() {
+		return true
+	}
+
+	for _, tag := range tags {
+		if tag == d.doTag {
+			return true
+		}
+	}
+	return false
+}
+
+// filterOwnedResources drops the volumes and snapshots not owned by the
+// driver from the given resources.
+func (d *Driver) filterOwnedResources(resources []interface{}) []interface{} {
+	owned := make([]interface{}, 0, len(resources))
+	for _, res := range resources {
+		var tags []string
+		switch r := res.(type) {
+		case godo.Volume:
+			tags = r.Tags
+		case godo.Snapshot:
+			tags = r.Tags
+		}
+
+		if d.ownsResource(tags) {
+			owned = append(owned, res)
+		}
+	}
+	return owned
+}
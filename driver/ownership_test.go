@@ -0,0 +1,143 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/digitalocean/godo"
+	"github.com/google/go-cmp/cmp"
+	"github.com/sirupsen/logrus"
+)
+
+func TestListOwnedResources(t *testing.T) {
+	const ownTag = "k8s:cluster-a"
+
+	tests := []struct {
+		name                 string
+		doTag                string
+		listUnownedResources bool
+		wantIDs              []string
+	}{
+		{
+			name:    "owned resources only",
+			doTag:   ownTag,
+			wantIDs: []string{"1", "3", "5"},
+		},
+		{
+			name:                 "unowned resources listed",
+			doTag:                ownTag,
+			listUnownedResources: true,
+			wantIDs:              []string{"1", "2", "3", "4", "5"},
+		},
+		{
+			name:    "no tag",
+			wantIDs: []string{"1", "2", "3", "4", "5"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tags := map[string][]string{
+				"1": {ownTag},
+				"2": nil,
+				"3": {"team-db", ownTag},
+				"4": {"k8s:cluster-b"},
+				"5": {ownTag},
+			}
+			volumes := map[string]*godo.Volume{}
+			snapshots := map[string]*godo.Snapshot{}
+			for id, t := range tags {
+				volumes[id] = &godo.Volume{ID: id, Tags: t}
+				snap := createGodoSnapshot(id, "snapshot-"+id, "")
+				snap.Tags = t
+				snapshots[id] = snap
+			}
+
+			d := &Driver{
+				region:               "nyc3",
+				doTag:                test.doTag,
+				listUnownedResources: test.listUnownedResources,
+				storage: &fakeStorageDriver{
+					volumes: volumes,
+				},
+				storageActions: &fakeStorageActionsDriver{
+					volumes: volumes,
+				},
+				snapshots: &fakeSnapshotsDriver{
+					snapshots: snapshots,
+				},
+				log: logrus.New().WithField("test_enabed", true),
+			}
+
+			volResp, err := d.ListVolumes(context.Background(), &csi.ListVolumesRequest{})
+			if err != nil {
+				t.Fatalf("failed to list volumes: %s", err)
+			}
+			var volumeIDs []string
+			for _, entry := range volResp.Entries {
+				volumeIDs = append(volumeIDs, entry.Volume.VolumeId)
+			}
+			sort.Strings(volumeIDs)
+			if diff := cmp.Diff(test.wantIDs, volumeIDs); diff != "" {
+				t.Errorf("volume IDs mismatch (-want +got):\n%s", diff)
+			}
+
+			// page through the snapshots to cover the paging of owned snapshots
+			var (
+				snapshotIDs []string
+				token       string
+			)
+			for {
+				snapResp, err := d.ListSnapshots(context.Background(), &csi.ListSnapshotsRequest{
+					MaxEntries:    2,
+					StartingToken: token,
+				})
+				if err != nil {
+					t.Fatalf("failed to list snapshots: %s", err)
+				}
+				for _, entry := range snapResp.Entries {
+					snapshotIDs = append(snapshotIDs, entry.Snapshot.SnapshotId)
+				}
+				token = snapResp.NextToken
+				if token == "" {
+					break
+				}
+			}
+			if diff := cmp.Diff(test.wantIDs, snapshotIDs); diff != "" {
+				t.Errorf("snapshot IDs mismatch (-want +got):\n%s", diff)
+			}
+
+			snapResp, err := d.ListSnapshots(context.Background(), &csi.ListSnapshotsRequest{
+				SnapshotId: "2",
+			})
+			if err != nil {
+				t.Fatalf("failed to list snapshot by ID: %s", err)
+			}
+			wantEntries := 1
+			if d.filtersOwnership() {
+				wantEntries = 0
+			}
+			if len(snapResp.Entries) != wantEntries {
+				t.Errorf("got %d entries for unowned snapshot, want %d", len(snapResp.Entries), wantEntries)
+			}
+		})
+	}
+}
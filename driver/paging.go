@@ -133,3 +133,28 @@ func listResources(ctx context.Context, log *logrus.Entry, startingToken, maxEnt
 
 	return resources, nextToken, nil
 }
+
+// pageResources returns the page of the given resources that starts at
+// startingToken and holds at most maxEntries resources, along with the token
+// of the next page. Tokens have the same meaning as in listResources.
+func pageResources(resources []interface{}, startingToken, maxEntries int32) ([]interface{}, int32) {
+	// StartingToken is not zero-based
+	start := int(startingToken)
+	if start > 0 {
+		start--
+	}
+	if start > len(resources) {
+		start = len(resources)
+	}
+	resources = resources[start:]
+
+	if maxEntries <= 0 || int(maxEntries) >= len(resources) {
+		return resources, 0
+	}
+
+	nextToken := startingToken + maxEntries
+	if startingToken == 0 {
+		nextToken++
+	}
+	return resources[:maxEntries], nextToken
+}
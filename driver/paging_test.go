@@ -0,0 +1,83 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPageResources(t *testing.T) {
+	resources := []interface{}{"a", "b", "c", "d", "e"}
+
+	tests := []struct {
+		name          string
+		startingToken int32
+		maxEntries    int32
+		wantResources []interface{}
+		wantNextToken int32
+	}{
+		{
+			name:          "all",
+			wantResources: resources,
+		},
+		{
+			name:          "first page",
+			maxEntries:    2,
+			wantResources: []interface{}{"a", "b"},
+			wantNextToken: 3,
+		},
+		{
+			name:          "second page",
+			startingToken: 3,
+			maxEntries:    2,
+			wantResources: []interface{}{"c", "d"},
+			wantNextToken: 5,
+		},
+		{
+			name:          "last page",
+			startingToken: 5,
+			maxEntries:    2,
+			wantResources: []interface{}{"e"},
+		},
+		{
+			name:          "exactly filled last page",
+			startingToken: 4,
+			maxEntries:    2,
+			wantResources: []interface{}{"d", "e"},
+		},
+		{
+			name:          "beyond the end",
+			startingToken: 10,
+			maxEntries:    2,
+			wantResources: []interface{}{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, nextToken := pageResources(resources, test.startingToken, test.maxEntries)
+			if diff := cmp.Diff(test.wantResources, got); diff != "" {
+				t.Errorf("resources mismatch (-want +got):\n%s", diff)
+			}
+			if nextToken != test.wantNextToken {
+				t.Errorf("got next token %d, want %d", nextToken, test.wantNextToken)
+			}
+		})
+	}
+}
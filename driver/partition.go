@@ -0,0 +1,72 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PartitionAttribute selects the partition of a pre-existing volume that is
+// staged instead of the whole device. It is set in the volume attributes of a
+// pre-provisioned PersistentVolume and takes either a partition number (e.g.
+// "1") or one of the blkid tags in partitionSelectorTags (e.g. "LABEL=data").
+const PartitionAttribute = DefaultDriverName + "/partition"
+
+// partitionSelectorTags are the blkid tags a partition can be selected by.
+var partitionSelectorTags = []string{"LABEL", "UUID", "PARTLABEL", "PARTUUID"}
+
+// partitionSelector identifies a partition of a volume either by its number
+// or by a blkid tag.
+type partitionSelector struct {
+	number int
+	tag    string
+	value  string
+}
+
+func (s partitionSelector) String() string {
+	if s.tag != "" {
+		return fmt.Sprintf("%s=%s", s.tag, s.value)
+	}
+	return strconv.Itoa(s.number)
+}
+
+// parsePartitionSelector parses the value of PartitionAttribute.
+func parsePartitionSelector(value string) (partitionSelector, error) {
+	i := strings.Index(value, "=")
+	if i < 0 {
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 1 {
+			return partitionSelector{}, fmt.Errorf("invalid partition %q: must be a partition number or one of %s=<value>", value, strings.Join(partitionSelectorTags, "=, "))
+		}
+		return partitionSelector{number: number}, nil
+	}
+
+	tag, tagValue := strings.ToUpper(value[:i]), value[i+1:]
+	for _, t := range partitionSelectorTags {
+		if tag != t {
+			continue
+		}
+		if tagValue == "" {
+			return partitionSelector{}, fmt.Errorf("invalid partition %q: %s must not be empty", value, tag)
+		}
+		return partitionSelector{tag: tag, value: tagValue}, nil
+	}
+
+	return partitionSelector{}, fmt.Errorf("invalid partition %q: tag %q is not one of %s", value, value[:i], strings.Join(partitionSelectorTags, ", "))
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"testing"
+)
+
+func TestParsePartitionSelector(t *testing.T) {
+	tests := []struct {
+		name         string
+		value        string
+		wantSelector partitionSelector
+		wantErr      bool
+	}{
+		{
+			name:         "number",
+			value:        "2",
+			wantSelector: partitionSelector{number: 2},
+		},
+		{
+			name:         "label",
+			value:        "LABEL=data",
+			wantSelector: partitionSelector{tag: "LABEL", value: "data"},
+		},
+		{
+			name:         "lower case uuid",
+			value:        "uuid=0f1c1a4e-8f5a-4bd6-9b5e-2f9c0d3b8a11",
+			wantSelector: partitionSelector{tag: "UUID", value: "0f1c1a4e-8f5a-4bd6-9b5e-2f9c0d3b8a11"},
+		},
+		{
+			name:         "partuuid",
+			value:        "PARTUUID=5e3c2a1b-01",
+			wantSelector: partitionSelector{tag: "PARTUUID", value: "5e3c2a1b-01"},
+		},
+		{
+			name:         "value with equal sign",
+			value:        "PARTLABEL=a=b",
+			wantSelector: partitionSelector{tag: "PARTLABEL", value: "a=b"},
+		},
+		{
+			name:    "zero",
+			value:   "0",
+			wantErr: true,
+		},
+		{
+			name:    "device name",
+			value:   "sda1",
+			wantErr: true,
+		},
+		{
+			name:    "unknown tag",
+			value:   "TYPE=ext4",
+			wantErr: true,
+		},
+		{
+			name:    "empty label",
+			value:   "LABEL=",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			selector, err := parsePartitionSelector(test.value)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error: %t", err, test.wantErr)
+			}
+			if selector != test.wantSelector {
+				t.Errorf("got selector %+v, want %+v", selector, test.wantSelector)
+			}
+		})
+	}
+}
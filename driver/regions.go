@@ -0,0 +1,182 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/digitalocean/godo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// legacyTopologyRegionKey is the topology key the region was published
+	// under originally.
+	legacyTopologyRegionKey = "region"
+
+	// TopologyRegionKey is the well-known Kubernetes topology key of the
+	// region.
+	TopologyRegionKey = "topology.kubernetes.io/region"
+)
+
+// Topology modes select the keys the region is published under in the
+// topology of nodes and volumes. Both keys are accepted in every mode.
+const (
+	// TopologyModeLegacy publishes the legacy key only.
+	TopologyModeLegacy = "legacy"
+	// TopologyModeMigration publishes both keys so that volumes carrying
+	// either key can be scheduled.
+	TopologyModeMigration = "migration"
+	// TopologyModeStandard publishes the well-known key only.
+	TopologyModeStandard = "standard"
+
+	// DefaultTopologyMode is the topology mode used if none is given.
+	DefaultTopologyMode = TopologyModeMigration
+)
+
+// validateTopologyMode returns an error if the given topology mode is
+// unknown.
+func validateTopologyMode(mode string) error {
+	switch mode {
+	case TopologyModeLegacy, TopologyModeMigration, TopologyModeStandard:
+		return nil
+	}
+	return fmt.Errorf("unknown topology mode %q, must be one of %q, %q or %q", mode, TopologyModeLegacy, TopologyModeMigration, TopologyModeStandard)
+}
+
+// topologySegments returns the topology segments of the given region with
+// the keys of the driver's topology mode.
+func (d *Driver) topologySegments(region string) map[string]string {
+	switch d.topologyMode {
+	case TopologyModeLegacy:
+		return map[string]string{legacyTopologyRegionKey: region}
+	case TopologyModeStandard:
+		return map[string]string{TopologyRegionKey: region}
+	}
+	return map[string]string{
+		legacyTopologyRegionKey: region,
+		TopologyRegionKey:       region,
+	}
+}
+
+// servedRegions returns the regions the controller manages volumes in. The
+// region of the driver always comes first.
+func (d *Driver) servedRegions() []string {
+	if len(d.regions) == 0 {
+		return []string{d.region}
+	}
+	return d.regions
+}
+
+// servesRegion returns whether the controller manages volumes in the given
+// region.
+func (d *Driver) servesRegion(region string) bool {
+	for _, r := range d.servedRegions() {
+		if r == region {
+			return true
+		}
+	}
+	return false
+}
+
+// volumeRegion returns the region the given volume lives in.
+func (d *Driver) volumeRegion(vol *godo.Volume) string {
+	if vol.Region != nil && vol.Region.Slug != "" {
+		return vol.Region.Slug
+	}
+	return d.region
+}
+
+// regionFromTopology returns the region of the given topology segment, if
+// any. The well-known key takes precedence over the legacy key.
+func regionFromTopology(topology *csi.Topology) (string, bool) {
+	if topology == nil {
+		return "", false
+	}
+	if region, ok := topology.Segments[TopologyRegionKey]; ok {
+		return region, true
+	}
+	region, ok := topology.Segments[legacyTopologyRegionKey]
+	return region, ok
+}
+
+// selectRegion picks the region to create a volume in from the given
+// accessibility requirements: the first served preferred region, or else the
+// first served requisite region. The driver's region is used if the
+// requirements do not constrain the region.
+//
+// Volumes restored from a snapshot or cloned from a volume can only be
+// created where their source lives. If source regions are given, only those
+// of them that are served are considered, and requirements that none of them
+// satisfy are rejected.
+func (d *Driver) selectRegion(requirements *csi.TopologyRequirement, sourceRegions []string) (string, error) {
+	candidates := d.servedRegions()
+	if len(sourceRegions) > 0 {
+		candidates = nil
+		for _, region := range d.servedRegions() {
+			if containsString(sourceRegions, region) {
+				candidates = append(candidates, region)
+			}
+		}
+		if len(candidates) == 0 {
+			return "", status.Errorf(codes.InvalidArgument, "volume source is located in regions %q, but volumes can be only created in regions: %q", sourceRegions, d.servedRegions())
+		}
+	}
+
+	if requirements == nil {
+		return candidates[0], nil
+	}
+
+	for _, t := range requirements.Preferred {
+		if region, ok := regionFromTopology(t); ok && containsString(candidates, region) {
+			return region, nil
+		}
+	}
+
+	var requisite []string
+	for _, t := range requirements.Requisite {
+		region, ok := regionFromTopology(t)
+		if !ok {
+			continue
+		}
+		if containsString(candidates, region) {
+			return region, nil
+		}
+		requisite = append(requisite, region)
+	}
+
+	if len(requisite) > 0 {
+		if len(sourceRegions) > 0 {
+			return "", status.Errorf(codes.InvalidArgument, "volume source is located in regions %q, but volume must be created in regions: %q", sourceRegions, requisite)
+		}
+		return "", status.Errorf(codes.ResourceExhausted, "volume can be only created in regions: %q, got: %q", d.servedRegions(), requisite)
+	}
+
+	return candidates[0], nil
+}
+
+// containsString returns whether the given string is among the given values.
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSelectRegion(t *testing.T) {
+	topology := func(region string) *csi.Topology {
+		return &csi.Topology{Segments: map[string]string{"region": region}}
+	}
+
+	tests := []struct {
+		name          string
+		requirements  *csi.TopologyRequirement
+		sourceRegions []string
+		wantRegion    string
+		wantCode      codes.Code
+	}{
+		{
+			name:       "no requirements",
+			wantRegion: "nyc3",
+		},
+		{
+			name: "preferred region",
+			requirements: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{topology("nyc3"), topology("fra1")},
+				Preferred: []*csi.Topology{topology("fra1"), topology("nyc3")},
+			},
+			wantRegion: "fra1",
+		},
+		{
+			name: "first served preferred region",
+			requirements: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{topology("sfo2"), topology("fra1")},
+				Preferred: []*csi.Topology{topology("sfo2"), topology("fra1")},
+			},
+			wantRegion: "fra1",
+		},
+		{
+			name: "first served requisite region",
+			requirements: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{topology("sfo2"), topology("fra1"), topology("nyc3")},
+			},
+			wantRegion: "fra1",
+		},
+		{
+			name: "no served requisite region",
+			requirements: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{topology("sfo2"), topology("ams3")},
+			},
+			wantCode: codes.ResourceExhausted,
+		},
+		{
+			name: "well-known key",
+			requirements: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{{Segments: map[string]string{TopologyRegionKey: "fra1"}}},
+			},
+			wantRegion: "fra1",
+		},
+		{
+			name: "well-known key takes precedence",
+			requirements: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{{Segments: map[string]string{TopologyRegionKey: "fra1", "region": "nyc3"}}},
+			},
+			wantRegion: "fra1",
+		},
+		{
+			name: "no region segments",
+			requirements: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{{Segments: map[string]string{"zone": "a"}}},
+			},
+			wantRegion: "nyc3",
+		},
+		{
+			name:          "source region without requirements",
+			sourceRegions: []string{"fra1"},
+			wantRegion:    "fra1",
+		},
+		{
+			name: "source region overrides preferred region",
+			requirements: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{topology("nyc3"), topology("fra1")},
+				Preferred: []*csi.Topology{topology("nyc3"), topology("fra1")},
+			},
+			sourceRegions: []string{"fra1"},
+			wantRegion:    "fra1",
+		},
+		{
+			name:          "first served source region",
+			sourceRegions: []string{"sfo2", "fra1", "nyc3"},
+			wantRegion:    "nyc3",
+		},
+		{
+			name: "source region not requisite",
+			requirements: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{topology("nyc3")},
+			},
+			sourceRegions: []string{"fra1"},
+			wantCode:      codes.InvalidArgument,
+		},
+		{
+			name:          "source region not served",
+			sourceRegions: []string{"sfo2"},
+			wantCode:      codes.InvalidArgument,
+		},
+	}
+
+	d := &Driver{
+		region:  "nyc3",
+		regions: []string{"nyc3", "fra1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			region, err := d.selectRegion(test.requirements, test.sourceRegions)
+			if code := status.Code(err); code != test.wantCode {
+				t.Fatalf("got code %s, want %s (error: %v)", code, test.wantCode, err)
+			}
+			if region != test.wantRegion {
+				t.Errorf("got region %q, want %q", region, test.wantRegion)
+			}
+		})
+	}
+}
+
+func TestTopologySegments(t *testing.T) {
+	tests := []struct {
+		mode         string
+		wantSegments map[string]string
+	}{
+		{
+			mode:         TopologyModeLegacy,
+			wantSegments: map[string]string{"region": "nyc3"},
+		},
+		{
+			mode:         TopologyModeMigration,
+			wantSegments: map[string]string{"region": "nyc3", TopologyRegionKey: "nyc3"},
+		},
+		{
+			mode:         TopologyModeStandard,
+			wantSegments: map[string]string{TopologyRegionKey: "nyc3"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			d := &Driver{topologyMode: test.mode}
+			if diff := cmp.Diff(test.wantSegments, d.topologySegments("nyc3")); diff != "" {
+				t.Errorf("segments mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if err := validateTopologyMode("zones"); err == nil {
+		t.Error("got no error for unknown topology mode")
+	}
+}
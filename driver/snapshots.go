@@ -0,0 +1,97 @@
+/*
+Copyright 2020 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+// snapshotActionType is matched against the types of volume actions to find
+// the ones taking snapshots.
+const snapshotActionType = "snapshot"
+
+// snapshotReadiness tells whether snapshots have been taken completely. The
+// in-progress snapshot actions of the source volumes are cached so that
+// listing many snapshots of the same volume costs a single API call.
+type snapshotReadiness struct {
+	storageActions godo.StorageActionsService
+	// inProgress holds the start times of the in-progress snapshot actions
+	// by source volume ID
+	inProgress map[string][]time.Time
+}
+
+func (d *Driver) newSnapshotReadiness() *snapshotReadiness {
+	return &snapshotReadiness{
+		storageActions: d.storageActions,
+		inProgress:     map[string][]time.Time{},
+	}
+}
+
+// ready returns whether the given snapshot has been taken completely. A
+// snapshot is in progress while its source volume has an in-progress snapshot
+// action that started no later than the snapshot was created. The size of the
+// snapshot is not taken into account since snapshots of empty volumes are
+// legitimately 0 GB large.
+func (r *snapshotReadiness) ready(ctx context.Context, snap *godo.Snapshot) (bool, error) {
+	if snap.ResourceID == "" {
+		return true, nil
+	}
+
+	starts, ok := r.inProgress[snap.ResourceID]
+	if !ok {
+		actions, resp, err := r.storageActions.List(ctx, snap.ResourceID, &godo.ListOptions{
+			Page:    1,
+			PerPage: 50,
+		})
+		if err != nil {
+			// snapshots outlive their source volumes, which cannot be
+			// deleted while a snapshot is taken
+			if resp == nil || resp.StatusCode != http.StatusNotFound {
+				return false, fmt.Errorf("failed to list actions of volume %s: %s", snap.ResourceID, err)
+			}
+		}
+
+		for _, action := range actions {
+			if action.Status == godo.ActionInProgress && strings.Contains(action.Type, snapshotActionType) && action.StartedAt != nil {
+				starts = append(starts, action.StartedAt.Time)
+			}
+		}
+		r.inProgress[snap.ResourceID] = starts
+	}
+
+	if len(starts) == 0 {
+		return true, nil
+	}
+
+	created, err := time.Parse(time.RFC3339, snap.Created)
+	if err != nil {
+		return false, fmt.Errorf("couldn't parse snapshot's created field: %s", err)
+	}
+	for _, start := range starts {
+		// the creation time only has a precision of seconds
+		if !created.Before(start.Truncate(time.Second)) {
+			return false, nil
+		}
+	}
+	return true, nil
+}